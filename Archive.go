@@ -0,0 +1,163 @@
+package ytdl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//ArchiveEntry describes downloaded video
+type ArchiveEntry struct {
+	VideoID string
+	//Itags are itags of downloaded video Format and merged audio Format
+	Itags []int
+}
+
+//Archive remembers downloaded videos so they are skipped next time
+//
+//Implementations must be safe for concurrent use
+type Archive interface {
+	//Has reports whether videoID was downloaded
+	Has(videoID string) (bool, error)
+	//Add records downloaded video
+	Add(entry ArchiveEntry) error
+}
+
+//FileArchive is Archive stored in text file, one video per line
+//
+//	youtube <VideoID> <itag>[+<itag>]
+//
+//Archive file is read on first use if FileArchive is not made by OpenArchive
+type FileArchive struct {
+	Filename string
+
+	mu      sync.Mutex
+	entries map[string]ArchiveEntry
+}
+
+//OpenArchive reads archive file, nonexistent file is treated as empty archive
+func OpenArchive(filename string) (*FileArchive, error) {
+	a := &FileArchive{Filename: filename}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+//load reads archive file into entries once, mu must be held
+func (a *FileArchive) load() error {
+	if a.entries != nil {
+		return nil
+	}
+	entries := map[string]ArchiveEntry{}
+
+	file, err := os.Open(a.Filename)
+	if os.IsNotExist(err) {
+		a.entries = entries
+		return nil
+	}
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	defer file.Close()
+
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || fields[0] != "youtube" {
+			continue
+		}
+
+		entry := ArchiveEntry{VideoID: fields[1]}
+		if len(fields) > 2 {
+			for _, itag := range strings.Split(fields[2], "+") {
+				if n, err := strconv.Atoi(itag); err == nil {
+					entry.Itags = append(entry.Itags, n)
+				}
+			}
+		}
+		entries[entry.VideoID] = entry
+	}
+	if err := sc.Err(); err != nil {
+		return e.DbgErr(err)
+	}
+	a.entries = entries
+	return nil
+}
+
+//Has implements Archive
+func (a *FileArchive) Has(videoID string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.load(); err != nil {
+		return false, err
+	}
+	_, ok := a.entries[videoID]
+	return ok, nil
+}
+
+//Entry returns recorded entry of videoID
+//
+//It returns false if archive file can't be read
+func (a *FileArchive) Entry(videoID string) (ArchiveEntry, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.load() != nil {
+		return ArchiveEntry{}, false
+	}
+	entry, ok := a.entries[videoID]
+	return entry, ok
+}
+
+//Add implements Archive by appending a line to archive file
+func (a *FileArchive) Add(entry ArchiveEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.load(); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(a.Filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	itags := make([]string, len(entry.Itags))
+	for i, itag := range entry.Itags {
+		itags[i] = strconv.Itoa(itag)
+	}
+	_, err = fmt.Fprintf(file, "youtube %s %s\n", entry.VideoID, strings.Join(itags, "+"))
+	if err != nil {
+		file.Close()
+		return e.DbgErr(err)
+	}
+
+	a.entries[entry.VideoID] = entry
+	return e.DbgErr(file.Close())
+}
+
+//archive returns Archive of options or Client
+func (c *Client) archive(options *DownloadOptions) Archive {
+	if options != nil && options.Archive != nil {
+		return options.Archive
+	}
+	return c.Archive
+}
+
+//archived reports whether videoID is in Archive of options or Client
+func (c *Client) archived(options *DownloadOptions, videoID string) (bool, error) {
+	a := c.archive(options)
+	if a == nil {
+		return false, nil
+	}
+	ok, err := a.Has(videoID)
+	return ok, e.DbgErr(err)
+}
@@ -0,0 +1,137 @@
+package ytdl
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sam1677/ytdl/internal/logger"
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//LoadVideoInfo reads player response JSON (e.g. cached jsonCache/<VideoID>.json) using DefaultClient
+func LoadVideoInfo(r io.Reader) (*VideoInfo, error) {
+	return DefaultClient.LoadVideoInfo(r)
+}
+
+//LoadVideoInfo reads player response JSON (e.g. cached jsonCache/<VideoID>.json)
+//and deciphers its Formats
+//
+//FetchedAt of returned VideoInfo is zero, so expiry is detected from stream URLs
+func (c *Client) LoadVideoInfo(r io.Reader) (*VideoInfo, error) {
+	rawJSON, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, e.Wrap(err, "LoadVideoInfo", "", 0)
+	}
+
+	vi, err := c.parseVideoInfo(rawJSON, time.Time{})
+	if err == nil {
+		err = vi.decipherAll()
+	}
+	if err != nil {
+		return nil, e.Wrap(err, "LoadVideoInfo", vi.videoID(), 0)
+	}
+	return vi, nil
+}
+
+//loadCachedVideoInfo loads player response cached by getVideoInfo
+//
+//Cached file's modification time is used as FetchedAt
+func (c *Client) loadCachedVideoInfo(VID string) (*VideoInfo, error) {
+	dir, ok := c.cacheDir(jsonCacheDir)
+	if !ok {
+		return nil, e.DbgErr(e.ErrCacheMiss)
+	}
+
+	name := filepath.Join(dir, VID+".json")
+	stat, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return nil, e.DbgErr(e.ErrCacheMiss)
+	}
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+
+	rawJSON, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+
+	vi, err := c.parseVideoInfo(rawJSON, stat.ModTime())
+	if err != nil {
+		return nil, err
+	}
+	if vi.Expired() {
+		return nil, e.DbgErr(e.ErrCacheExpired)
+	}
+
+	logger.Debug(c.Logger, "using cached video info", "videoID", VID, "expiresAt", vi.ExpiresAt())
+	err = vi.decipherAll()
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	return vi, nil
+}
+
+//loadCachedScript loads player script cached by getDecipherer
+func (c *Client) loadCachedScript() ([]byte, error) {
+	dir, ok := c.cacheDir(scriptCacheDir)
+	if !ok {
+		return nil, e.DbgErr(e.ErrCacheMiss)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "script.js"))
+	if os.IsNotExist(err) {
+		return nil, e.DbgErr(e.ErrCacheMiss)
+	}
+	return data, e.DbgErr(err)
+}
+
+//ExpiresAt returns when stream URLs of VideoInfo expire
+//
+//It's FetchedAt + StreamingData.ExpiresInSeconds
+//or "expire" parameter of stream URL if FetchedAt is unknown.
+//Zero time is returned if both are unknown
+func (vi *VideoInfo) ExpiresAt() time.Time {
+	sec, err := strconv.ParseInt(vi.StreamingData.ExpiresInSeconds, 10, 64)
+	if !vi.FetchedAt.IsZero() && err == nil {
+		return vi.FetchedAt.Add(time.Duration(sec) * time.Second)
+	}
+
+	for _, f := range vi.CombinedFormatList() {
+		rawURL := f.URL
+		if rawURL == "" {
+			query, err := url.ParseQuery(f.SignatureCipher)
+			if err != nil {
+				continue
+			}
+			rawURL = query.Get("url")
+		}
+
+		URL, err := url.Parse(rawURL)
+		if err != nil {
+			continue
+		}
+		if expire, err := strconv.ParseInt(URL.Query().Get("expire"), 10, 64); err == nil {
+			return time.Unix(expire, 0)
+		}
+	}
+	return time.Time{}
+}
+
+//Expired reports whether stream URLs of VideoInfo are expired
+func (vi *VideoInfo) Expired() bool {
+	expiresAt := vi.ExpiresAt()
+	return !expiresAt.IsZero() && time.Now().After(expiresAt)
+}
+
+func (vi *VideoInfo) videoID() string {
+	if vi == nil {
+		return ""
+	}
+	return vi.VideoDetails.VideoID
+}
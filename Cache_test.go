@@ -0,0 +1,70 @@
+package ytdl_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sam1677/ytdl"
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+const cachedPlayerResponse = `{
+	"playabilityStatus": {"status": "OK"},
+	"streamingData": {
+		"expiresInSeconds": "21540",
+		"adaptiveFormats": [
+			{"itag": 137, "url": "https://example.com/videoplayback?itag=137&expire=1", "mimeType": "video/mp4", "qualityLabel": "1080p", "quality": "hd1080"},
+			{"itag": 140, "url": "https://example.com/videoplayback?itag=140&expire=1", "mimeType": "audio/mp4", "quality": "tiny"}
+		]
+	},
+	"videoDetails": {"videoId": "9bZkp7q19f0", "title": "PSY - GANGNAM STYLE"}
+}`
+
+func TestLoadVideoInfo(t *testing.T) {
+	vi, err := ytdl.LoadVideoInfo(strings.NewReader(cachedPlayerResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vi.StreamingData.AdaptiveFormats.Videos().First().Filename != "9bZkp7q19f0-1080p-hd1080.mp4" {
+		t.Errorf("unexpected Filename %q", vi.StreamingData.AdaptiveFormats.First().Filename)
+	}
+	if !vi.Expired() {
+		t.Errorf("stream URL with expire=1 is not reported as expired (ExpiresAt: %v)", vi.ExpiresAt())
+	}
+}
+
+func TestOfflineClient(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "ytdl-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	c := &ytdl.Client{CacheDir: cacheDir, Offline: true}
+
+	_, err = c.GetVideoInfo("9bZkp7q19f0")
+	if !errors.Is(err, e.ErrCacheMiss) {
+		t.Errorf("got %v, want ErrCacheMiss", err)
+	}
+
+	err = os.MkdirAll(filepath.Join(cacheDir, "jsonCache"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(cacheDir, "jsonCache", "9bZkp7q19f0.json"), []byte(cachedPlayerResponse), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vi, err := c.GetVideoInfo("https://www.youtube.com/watch?v=9bZkp7q19f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vi.Expired() || vi.FetchedAt.IsZero() {
+		t.Errorf("freshly cached video info is expired (FetchedAt: %v)", vi.FetchedAt)
+	}
+}
@@ -0,0 +1,95 @@
+package ytdl
+
+import (
+	"net/url"
+	"strings"
+)
+
+//CaptionTrack describes captionTracks JSON type
+type CaptionTrack struct {
+	BaseURL        string     `json:"baseUrl"`
+	Name           SimpleText `json:"name"`
+	VssID          string     `json:"vssId"`
+	LanguageCode   string     `json:"languageCode"`
+	Kind           string     `json:"kind,omitempty"`
+	IsTranslatable bool       `json:"isTranslatable"`
+}
+
+//IsAuto reports whether caption track is generated by speech recognition
+func (ct CaptionTrack) IsAuto() bool {
+	return ct.Kind == "asr"
+}
+
+//URL returns URL of caption track in format ("vtt", "srv3", "json3"...)
+func (ct CaptionTrack) URL(format string) string {
+	u, err := url.Parse(ct.BaseURL)
+	if err != nil {
+		return ct.BaseURL
+	}
+	q := u.Query()
+	q.Set("fmt", format)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+//CaptionTracks returns caption tracks of video
+func (vi *VideoInfo) CaptionTracks() []CaptionTrack {
+	return vi.Captions.PlayerCaptionsTracklistRenderer.CaptionTracks
+}
+
+//SelectCaptions returns a caption track for each of languages which video has
+//
+//Manual tracks are preferred, auto generated ones are selected only if auto is set.
+//Language "en" matches "en" first and then regional tracks like "en-GB"
+func (vi *VideoInfo) SelectCaptions(languages []string, auto bool) []CaptionTrack {
+	tracks := vi.CaptionTracks()
+	selected := []CaptionTrack{}
+	for _, lang := range languages {
+		lang = strings.ToLower(lang)
+		best, score := -1, 0
+		for i, ct := range tracks {
+			if ct.IsAuto() && !auto {
+				continue
+			}
+			code := strings.ToLower(ct.LanguageCode)
+			s := 0
+			switch {
+			case code == lang:
+				s = 2
+			case strings.HasPrefix(code, lang+"-"):
+				s = 1
+			default:
+				continue
+			}
+			if !ct.IsAuto() {
+				s += 2
+			}
+			if s > score {
+				best, score = i, s
+			}
+		}
+		if best >= 0 {
+			selected = append(selected, tracks[best])
+		}
+	}
+	return selected
+}
+
+//iso639 maps ISO 639-1 language codes to ISO 639-2 codes which mp4 needs for language tags
+var iso639 = map[string]string{
+	"ar": "ara", "bn": "ben", "cs": "ces", "da": "dan", "de": "deu", "el": "ell",
+	"en": "eng", "es": "spa", "fa": "fas", "fi": "fin", "fr": "fra", "he": "heb",
+	"hi": "hin", "hu": "hun", "id": "ind", "it": "ita", "ja": "jpn", "ko": "kor",
+	"ms": "msa", "nl": "nld", "no": "nor", "pl": "pol", "pt": "por", "ro": "ron",
+	"ru": "rus", "sv": "swe", "ta": "tam", "th": "tha", "tr": "tur", "uk": "ukr",
+	"vi": "vie", "zh": "zho",
+}
+
+//languageTag returns ISO 639-2 code of language code like "en-US" (language itself if it's unknown)
+func languageTag(language string) string {
+	code := strings.ToLower(strings.SplitN(language, "-", 2)[0])
+	if tag, ok := iso639[code]; ok {
+		return tag
+	}
+	return language
+}
@@ -0,0 +1,126 @@
+package ytdl_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sam1677/ytdl"
+)
+
+const captionTracks = `"captions": {"playerCaptionsTracklistRenderer": {"captionTracks": [
+	{"baseUrl": "MEDIA/api/timedtext?v=9bZkp7q19f0&lang=en&kind=asr", "name": {"simpleText": "English (auto-generated)"}, "vssId": "a.en", "languageCode": "en", "kind": "asr"},
+	{"baseUrl": "MEDIA/api/timedtext?v=9bZkp7q19f0&lang=en-GB", "name": {"simpleText": "English (United Kingdom)"}, "vssId": ".en-GB", "languageCode": "en-GB"},
+	{"baseUrl": "MEDIA/api/timedtext?v=9bZkp7q19f0&lang=ko&kind=asr", "name": {"simpleText": "Korean (auto-generated)"}, "vssId": "a.ko", "languageCode": "ko", "kind": "asr"}
+]}},`
+
+func TestSelectCaptions(t *testing.T) {
+	vi, err := ytdl.LoadVideoInfo(strings.NewReader(strings.Replace(cachedPlayerResponse, `"videoDetails"`, captionTracks+`"videoDetails"`, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		languages []string
+		auto      bool
+		want      []string
+	}{
+		{[]string{"en", "ko"}, false, []string{".en-GB"}},
+		{[]string{"en", "ko"}, true, []string{".en-GB", "a.ko"}},
+		{[]string{"ja"}, true, []string{}},
+	} {
+		got := []string{}
+		for _, ct := range vi.SelectCaptions(tc.languages, tc.auto) {
+			got = append(got, ct.VssID)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("SelectCaptions(%v, %v) = %v, want %v", tc.languages, tc.auto, got, tc.want)
+		}
+	}
+
+	if u := vi.CaptionTracks()[1].URL("vtt"); !strings.Contains(u, "fmt=vtt") || !strings.Contains(u, "lang=en-GB") {
+		t.Errorf("unexpected caption URL %s", u)
+	}
+}
+
+func TestEmbedSubtitles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	ts := newMediaServer()
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+	cache := filepath.Join(dir, "jsonCache", "9bZkp7q19f0.json")
+	data, err := ioutil.ReadFile(cache)
+	if err == nil {
+		data = []byte(strings.Replace(string(data), `"videoDetails"`, strings.Replace(captionTracks, "MEDIA", ts.URL, -1)+`"videoDetails"`, 1))
+		err = ioutil.WriteFile(cache, data, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.FFMpegPath = filepath.Join(dir, "ffmpeg")
+	ffmpegScript := strings.Replace(fakeFFMpeg, `echo " ------"`, `echo " ------"; echo " S..... mov_text 3GPP"`, 1)
+	err = ioutil.WriteFile(c.FFMpegPath, []byte(ffmpegScript), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "ffmpeg.log")
+	os.Setenv("FFMPEG_LOG", log)
+	defer os.Unsetenv("FFMPEG_LOG")
+
+	vi, err := c.GetVideoInfo("9bZkp7q19f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vi.StreamingData.AdaptiveFormats.Videos().Best().Download(&ytdl.DownloadOptions{
+		Filename:      "video.mp4",
+		Subtitles:     []string{"en", "ko"},
+		AutoSubtitles: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "Downloads", "video.mp4")); err != nil {
+		t.Error(err)
+	}
+	logged, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"2.vtt -map 0 -map 1 -map 2 -c copy -c:s mov_text ",
+		"-metadata:s:s:0 language=eng -metadata:s:s:0 title=English (United Kingdom) ",
+		"-metadata:s:s:1 language=kor -metadata:s:s:1 title=Korean (auto-generated) ",
+	} {
+		if !strings.Contains(string(logged), want) {
+			t.Errorf("ffmpeg log doesn't contain %q:\n%s", want, logged)
+		}
+	}
+
+	// subtitle stream of input shifts indexes of embedded ones
+	err = ioutil.WriteFile(filepath.Join(dir, "ffprobe"),
+		[]byte("#!/bin/sh\necho '{\"streams\": [{\"index\": 2, \"codec_type\": \"subtitle\"}], \"format\": {}}'\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(log)
+	err = vi.StreamingData.AdaptiveFormats.Videos().Best().Download(&ytdl.DownloadOptions{
+		Filename:  "video.mp4",
+		Subtitles: []string{"en"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged, _ = ioutil.ReadFile(log)
+	if want := "-metadata:s:s:1 language=eng "; !strings.Contains(string(logged), want) {
+		t.Errorf("ffmpeg log doesn't contain %q:\n%s", want, logged)
+	}
+}
@@ -0,0 +1,279 @@
+package ytdl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sam1677/ytdl/internal/ffmpeg"
+	"github.com/sam1677/ytdl/internal/logger"
+	"github.com/sam1677/ytdl/internal/mkv"
+	fmp4 "github.com/sam1677/ytdl/internal/mp4"
+	u "github.com/sam1677/ytdl/internal/utils"
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//Errors of clip extraction
+var (
+	//ErrClipUnsupported Format's index can't be read or it isn't mp4 or webm
+	ErrClipUnsupported = e.New(e.ErrYtdl, "format does not support clip extraction")
+	//ErrClipOutOfRange DownloadOptions.Start and End don't cover any part of video
+	ErrClipOutOfRange = e.New(e.ErrYtdl, "clip is out of video")
+)
+
+//ByteRange describes initRange and indexRange JSON type
+type ByteRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+//Int returns start and end (inclusive) of ByteRange
+func (br *ByteRange) Int() (start, end int64, err error) {
+	start, err = strconv.ParseInt(br.Start, 10, 64)
+	if err == nil {
+		end, err = strconv.ParseInt(br.End, 10, 64)
+	}
+	if err == nil && end < start {
+		err = fmt.Errorf("invalid range %s-%s", br.Start, br.End)
+	}
+	return start, end, e.DbgErr(err)
+}
+
+//segment is media segment of Format
+type segment struct {
+	start, duration float64
+	offset, size    int64
+}
+
+//isClip reports whether options requests clip extraction
+func (options *DownloadOptions) isClip() bool {
+	return options.Start > 0 || options.End > 0
+}
+
+//fetch downloads Format or its clip into path/filename
+//
+//It returns start time of downloaded media in seconds which is 0 unless it's a clip.
+//Format without index (e.g. progressive formats) is downloaded entirely and cut by trimClip
+func (f *Format) fetch(path, filename string, options *DownloadOptions, limit *u.TokenBucket) (*os.File, float64, error) {
+	indexed := f.InitRange != nil && f.IndexRange != nil
+	if options.isClip() && !indexed {
+		logger.Info(f.client().Logger, "format has no index, downloading whole video for clip", "itag", f.Itag)
+	}
+	if !options.isClip() || !indexed {
+		file, err := f.downloadWithPath(path, filename, limit)
+		return file, 0, err
+	}
+	return f.downloadClip(path, filename, options.Start, options.End, limit)
+}
+
+//downloadClip downloads initialization segment and media segments covering start to end
+//
+//End 0 means the end of video. Clip starts at the start of segment which contains start,
+//so it returns the start time of the first segment in seconds
+func (f *Format) downloadClip(path, filename string, start, end time.Duration, limit *u.TokenBucket) (*os.File, float64, error) {
+	c := f.client()
+	d := c.downloader(f.videoID())
+	if limit != nil {
+		d.Bandwidth = append(d.Bandwidth, limit)
+	}
+
+	var (
+		file        *os.File
+		first, last segment
+		written     int64
+	)
+	err := f.withFreshURL(func() error {
+		if file == nil {
+			segments, init, err := f.segments()
+			if err != nil {
+				return err
+			}
+			from, to, err := clipSegments(segments, start, end)
+			if err != nil {
+				return err
+			}
+			first, last = segments[from], segments[to]
+
+			file, err = d.CreateFile(path, filename)
+			if err != nil {
+				return err
+			}
+			logger.Info(c.Logger, "start downloading clip", "file", filename, "itag", f.Itag,
+				"from", first.start, "to", last.start+last.duration)
+			if _, err = file.Write(init); err != nil {
+				return err
+			}
+		}
+
+		n, err := d.DownloadRange(file, f.URL, first.offset+written, last.offset+last.size-1)
+		written += n
+		return err
+	})
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, 0, e.DbgErr(err)
+	}
+	return file, first.start, nil
+}
+
+//clipSegments returns indexes of the first and the last segments covering start to end
+func clipSegments(segments []segment, start, end time.Duration) (from, to int, err error) {
+	from, to = -1, -1
+	for i, s := range segments {
+		if s.start+s.duration <= start.Seconds() {
+			continue
+		}
+		if end > 0 && s.start >= end.Seconds() {
+			break
+		}
+		if from < 0 {
+			from = i
+		}
+		to = i
+	}
+	if from < 0 {
+		return 0, 0, e.DbgErr(fmt.Errorf("%w: %v-%v", ErrClipOutOfRange, start, end))
+	}
+	return from, to, nil
+}
+
+//segments downloads initialization segment and index and returns segments
+func (f *Format) segments() ([]segment, []byte, error) {
+	if f.InitRange == nil || f.IndexRange == nil {
+		return nil, nil, e.DbgErr(fmt.Errorf("%w: itag %d has no index", ErrClipUnsupported, f.Itag))
+	}
+	initStart, initEnd, err := f.InitRange.Int()
+	if err != nil {
+		return nil, nil, e.DbgErr(err)
+	}
+	indexStart, indexEnd, err := f.IndexRange.Int()
+	if err != nil {
+		return nil, nil, e.DbgErr(err)
+	}
+
+	d := f.client().downloader(f.videoID())
+	init, index := new(bytes.Buffer), new(bytes.Buffer)
+	_, err = d.DownloadRange(init, f.URL, initStart, initEnd)
+	if err == nil {
+		_, err = d.DownloadRange(index, f.URL, indexStart, indexEnd)
+	}
+	if err != nil {
+		return nil, nil, e.DbgErr(err)
+	}
+
+	segments := []segment{}
+	switch {
+	case f.isMP4():
+		segs, err := fmp4.ParseIndex(index.Bytes(), indexStart)
+		if err != nil {
+			return nil, nil, e.DbgErr(err)
+		}
+		for _, s := range segs {
+			segments = append(segments, segment{s.Start, s.Duration, s.Offset, s.Size})
+		}
+
+	case f.isWebM():
+		length, err := strconv.ParseInt(f.ContentLength, 10, 64)
+		if err != nil {
+			return nil, nil, e.DbgErr(fmt.Errorf("%w: content length is unknown", ErrClipUnsupported))
+		}
+		var duration float64
+		if f.Parent != nil {
+			duration, _ = strconv.ParseFloat(f.Parent.VideoDetails.LengthSeconds, 64)
+		}
+		segs, err := mkv.ParseIndex(init.Bytes(), index.Bytes(), length, duration)
+		if err != nil {
+			return nil, nil, e.DbgErr(err)
+		}
+		for _, s := range segs {
+			segments = append(segments, segment{s.Start, s.Duration, s.Offset, s.Size})
+		}
+
+	default:
+		return nil, nil, e.DbgErr(fmt.Errorf("%w: %s", ErrClipUnsupported, f.MimeType))
+	}
+	return segments, init.Bytes(), nil
+}
+
+//trimClip cuts media from DownloadOptions.Start to End
+//
+//Streams are copied (cut at keyframes) unless ClipAccurate is set.
+//If ffmpeg is not available and ClipAccurate is not set, media is left cut at segments
+type trimClip struct{}
+
+//Process implements PostProcessor
+func (trimClip) Process(pc *PostProcessContext) error {
+	options := pc.Options
+	if _, err := pc.Client.ffmpeg(); err != nil {
+		if options.ClipAccurate {
+			return e.DbgErr(err)
+		}
+		logger.Warn(pc.Client.Logger, "ffmpeg is not available, clip is cut at segments", "error", err)
+		return nil
+	}
+
+	for _, src := range []*string{&pc.Path, &pc.AudioPath} {
+		if *src == "" {
+			continue
+		}
+		out, err := pc.TempPath(filepath.Ext(*src))
+		if err != nil {
+			return e.DbgErr(err)
+		}
+
+		cmd := &ffmpeg.Command{Overwrite: true}
+		in := cmd.Input(*src, "-ss", seconds(options.Start.Seconds()-pc.Start))
+		o := cmd.Output(out).Map(in, "")
+		if options.End > 0 {
+			o.Set("-t", seconds((options.End - options.Start).Seconds()))
+		}
+		if !options.ClipAccurate {
+			o.Codec("", "copy")
+		}
+		o.Set("-avoid_negative_ts", "make_zero")
+
+		err = pc.run(cmd)
+		if err != nil {
+			return e.DbgErr(err)
+		}
+		*src = out
+	}
+	pc.Start = options.Start.Seconds()
+	return nil
+}
+
+func seconds(s float64) string {
+	if s < 0 {
+		s = 0
+	}
+	return strconv.FormatFloat(s, 'f', 3, 64)
+}
+
+//moveFile renames src to dst and copies it if they are on different devices
+func moveFile(src, dst string) error {
+	if os.Rename(src, dst) == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return e.DbgErr(err)
+}
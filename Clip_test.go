@@ -0,0 +1,143 @@
+package ytdl_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sam1677/ytdl"
+)
+
+const clipPlayerResponse = `{
+	"playabilityStatus": {"status": "OK"},
+	"streamingData": {
+		"adaptiveFormats": [
+			{"itag": 137, "url": "MEDIA_URL/videoplayback?itag=137", "mimeType": "video/mp4; codecs=\"avc1.640028\"",
+				"initRange": {"start": "0", "end": "15"}, "indexRange": {"start": "16", "end": "83"}}
+		]
+	},
+	"videoDetails": {"videoId": "9bZkp7q19f0", "title": "clip", "lengthSeconds": "3"}
+}`
+
+//newSegmentedMP4 creates ftyp, sidx of 3 segments of 1 second and segments filled with 'A', 'B' and 'C'
+func newSegmentedMP4() []byte {
+	buf := new(bytes.Buffer)
+	put := func(vals ...uint32) {
+		for _, v := range vals {
+			binary.Write(buf, binary.BigEndian, v)
+		}
+	}
+
+	put(16)
+	buf.WriteString("ftypdash")
+	put(0)
+	put(68)
+	buf.WriteString("sidx")
+	put(0, 1, 1000, 0, 0, 3)
+	for i := 0; i < 3; i++ {
+		put(1000, 1000, 0x90000000)
+	}
+	for _, c := range "ABC" {
+		buf.WriteString(strings.Repeat(string(c), 1000))
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadClip(t *testing.T) {
+	media := newSegmentedMP4()
+	var served int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &countingResponseWriter{ResponseWriter: w}
+		http.ServeContent(cw, r, "", time.Time{}, bytes.NewReader(media))
+		served += cw.n
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "ytdl-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//ffmpeg is not used so the clip is cut at segments
+	c := &ytdl.Client{DisableCache: true, DownloadDir: dir, TempDir: dir, FFMpegPath: filepath.Join(dir, "no-ffmpeg")}
+	vi, err := c.LoadVideoInfo(strings.NewReader(strings.Replace(clipPlayerResponse, "MEDIA_URL", ts.URL, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	video := vi.StreamingData.AdaptiveFormats.Videos().First()
+	err = video.Download(&ytdl.DownloadOptions{Filename: "clip.mp4", Start: 1200 * time.Millisecond, End: 2500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "clip.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := string(media[:16]) + strings.Repeat("B", 1000) + strings.Repeat("C", 1000)
+	if string(data) != want {
+		t.Errorf("clip has %d bytes, starts with %q", len(data), data[:20])
+	}
+	if served != 16+68+2000 {
+		t.Errorf("%d bytes are downloaded", served)
+	}
+
+	err = video.Download(&ytdl.DownloadOptions{Filename: "late.mp4", Start: 10 * time.Second})
+	if !errors.Is(err, ytdl.ErrClipOutOfRange) {
+		t.Errorf("clip out of video: got %v", err)
+	}
+}
+
+func TestDownloadClipWithoutIndex(t *testing.T) {
+	ts := newMediaServer()
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "ytdl-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//progressive format has no initRange and indexRange, so the whole video is downloaded
+	c := &ytdl.Client{DisableCache: true, DownloadDir: dir, TempDir: dir, FFMpegPath: filepath.Join(dir, "no-ffmpeg")}
+	vi, err := c.LoadVideoInfo(strings.NewReader(`{
+		"playabilityStatus": {"status": "OK"},
+		"streamingData": {
+			"formats": [{"itag": 18, "url": "` + ts.URL + `/videoplayback?itag=18", "mimeType": "video/mp4; codecs=\"avc1.42001E, mp4a.40.2\""}]
+		},
+		"videoDetails": {"videoId": "9bZkp7q19f0", "title": "clip", "lengthSeconds": "3"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vi.StreamingData.Formats[0].Download(&ytdl.DownloadOptions{Filename: "clip.mp4", Start: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "clip.mp4"))
+	if err != nil || string(data) != "18-media" {
+		t.Errorf("got %q, %v", data, err)
+	}
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (cw *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,139 @@
+package ytdl
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//container lists codecs which container stores as they are
+type container struct {
+	ext string
+	//video and audio are codecs stored without transcoding,
+	//other codecs are transcoded into the first one
+	//
+	//nil means every codec is stored
+	video, audio []string
+}
+
+//containers are targets of DownloadOptions.Container and Remux
+var containers = map[string]container{
+	"mp4":  {".mp4", []string{"h264", "hevc", "av1", "vp9"}, []string{"aac", "mp3", "ac3", "eac3", "alac"}},
+	"mov":  {".mov", []string{"h264", "hevc", "mpeg4", "prores"}, []string{"aac", "mp3", "ac3", "alac"}},
+	"webm": {".webm", []string{"vp9", "vp8", "av1"}, []string{"opus", "vorbis"}},
+	"mkv":  {".mkv", nil, nil},
+}
+
+//encoders are ffmpeg encoders of codecs which streams are transcoded into
+var encoders = map[string]string{
+	"h264": "libx264",
+	"vp9":  "libvpx-vp9",
+	"aac":  "aac",
+	"opus": "libopus",
+}
+
+//convert returns codec which codec is transcoded into, or "" if it's stored as it is
+//
+//Unknown codec ("") is stored as it is
+func convert(codec string, codecs []string) string {
+	if codec == "" || codecs == nil {
+		return ""
+	}
+	for _, c := range codecs {
+		if c == codec {
+			return ""
+		}
+	}
+	return codecs[0]
+}
+
+//encoder returns ffmpeg codec argument which transcodes stream into codec ("" means copy)
+func encoder(codec string) string {
+	if codec == "" {
+		return "copy"
+	}
+	return encoders[codec]
+}
+
+//target returns container which Path is converted into and codecs which its video and audio
+//are transcoded into ("" means copy)
+//
+//Empty name keeps container of Path if it supports codecs of Path, otherwise mkv is used
+func (pc *PostProcessContext) target(name string) (ct container, video, audio string, err error) {
+	return targetContainer(name, filepath.Ext(pc.Path), pc.HasVideo, pc.vcodec, pc.acodec)
+}
+
+//targetContainer returns container name of media in container of ext is converted into,
+//and codecs which its video and audio are transcoded into (see PostProcessContext.target)
+func targetContainer(name, ext string, hasVideo bool, vcodec, acodec string) (ct container, video, audio string, err error) {
+	name = strings.ToLower(strings.TrimPrefix(name, "."))
+	if name == "" {
+		ct, ok := containers[strings.TrimPrefix(ext, ".")]
+		if !ok {
+			//unknown container is kept as it is
+			return container{ext: ext}, "", "", nil
+		}
+
+		name = ct.ext[1:]
+		if (hasVideo && convert(vcodec, ct.video) != "") || convert(acodec, ct.audio) != "" {
+			name = "mkv"
+		}
+	}
+
+	ct, ok := containers[name]
+	if !ok {
+		return ct, "", "", e.DbgErr(fmt.Errorf("%w: unknown container %q", e.ErrYtdl, name))
+	}
+	if hasVideo {
+		video = convert(vcodec, ct.video)
+	}
+	audio = convert(acodec, ct.audio)
+	return ct, video, audio, nil
+}
+
+//resolveContainer sets extension of options.Filename to container which Merge and Remux produce,
+//so collisions are resolved before downloading
+//
+//Filename with extension of other container than Format's (e.g. "video.mkv") sets Container if it's empty
+func (f *Format) resolveContainer(options *DownloadOptions) error {
+	ext := filepath.Ext(options.Filename)
+	if options.Container == "" && ext != f.ext() {
+		if _, ok := containers[strings.TrimPrefix(ext, ".")]; ok {
+			options.Container = ext[1:]
+		}
+	}
+
+	acodec := f.audioCodec()
+	if options.AudioOverride != nil {
+		acodec = options.AudioOverride.audioCodec()
+	}
+	ct, _, _, err := targetContainer(options.Container, f.ext(), f.hasVideo(), f.videoCodec(), acodec)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	options.Filename = strings.TrimSuffix(options.Filename, ext) + ct.ext
+	return nil
+}
+
+//ext returns extension of downloaded Format with dot
+func (f *Format) ext() string {
+	if ext := filepath.Ext(f.Filename); ext != "" {
+		return ext
+	}
+	if ext := f.Ext(); ext != "" {
+		return "." + ext
+	}
+	return ""
+}
+
+//transcoded records codecs of Path after its streams are transcoded into video and audio
+func (pc *PostProcessContext) transcoded(video, audio string) {
+	if video != "" {
+		pc.vcodec = video
+	}
+	if audio != "" {
+		pc.acodec = audio
+	}
+}
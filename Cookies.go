@@ -0,0 +1,54 @@
+package ytdl
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sam1677/ytdl/internal/cookies"
+)
+
+//CookieJar is http.CookieJar which can be loaded from and saved to Netscape cookies.txt
+//
+//Cookies set by Youtube during requests are kept, call Save to persist them
+type CookieJar = cookies.Jar
+
+//NewCookieJar creates empty CookieJar
+func NewCookieJar() *CookieJar {
+	return cookies.New()
+}
+
+//LoadCookies loads Netscape cookies.txt (e.g. exported from browser) into CookieJar
+//
+//CookieJar.Save writes cookies back to filename
+func LoadCookies(filename string) (*CookieJar, error) {
+	return cookies.Load(filename)
+}
+
+const youtubeOrigin = "https://www.youtube.com"
+
+var youtubeURL = &url.URL{Scheme: "https", Host: "www.youtube.com", Path: "/"}
+
+//authorize adds SAPISIDHASH authorization to requests to Youtube if Cookies have SAPISID
+func (c *Client) authorize(req *http.Request) {
+	if c.Cookies == nil || !isYoutubeHost(req.URL.Hostname()) {
+		return
+	}
+
+	sapisid, ok := c.Cookies.Get(youtubeURL, "SAPISID")
+	if !ok {
+		sapisid, ok = c.Cookies.Get(youtubeURL, "__Secure-3PAPISID")
+	}
+	if !ok {
+		return
+	}
+
+	req.Header.Set("Authorization", cookies.SAPISIDHash(sapisid, youtubeOrigin, time.Now()))
+	req.Header.Set("X-Origin", youtubeOrigin)
+	req.Header.Set("X-Goog-AuthUser", "0")
+}
+
+func isYoutubeHost(host string) bool {
+	return host == "youtube.com" || strings.HasSuffix(host, ".youtube.com")
+}
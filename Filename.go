@@ -0,0 +1,133 @@
+package ytdl
+
+import (
+	"bytes"
+	"mime"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//FilenameData is data given to DownloadOptions.FilenameTemplate
+//
+//	"{{.Author}}/{{.UploadDate}} - {{.Title}} [{{.VideoID}}].{{.Ext}}"
+//
+//Path separators in string fields are replaced with "_",
+//so only separators written in the template create directories.
+//VideoDetails, Microformat and Format are given as they are
+type FilenameData struct {
+	VideoID      string
+	Title        string
+	Author       string
+	ChannelID    string
+	UploadDate   string
+	PublishDate  string
+	Category     string
+	Length       string
+	ViewCount    string
+	Itag         int
+	AudioItag    int
+	Quality      string
+	QualityLabel string
+	FPS          int
+	Width        int
+	Height       int
+	Type         string
+	Ext          string
+
+	VideoDetails *VideoDetails
+	Microformat  *PlayerMicroformatRenderer
+	Format       *Format
+	Audio        *Format
+}
+
+//FilenameData returns FilenameData of Format which is merged with audio (may be nil)
+func (f *Format) FilenameData(audio *Format) FilenameData {
+	fd := FilenameData{
+		Itag:         f.Itag,
+		Quality:      f.Quality,
+		QualityLabel: f.QualityLabel,
+		FPS:          f.FPS,
+		Width:        f.Width,
+		Height:       f.Height,
+		Type:         f.Type,
+		Ext:          f.Ext(),
+		Format:       f,
+		Audio:        audio,
+	}
+	if audio != nil {
+		fd.AudioItag = audio.Itag
+	}
+
+	if vi := f.Parent; vi != nil {
+		vd := &vi.VideoDetails
+		mf := &vi.Microformat.PlayerMicroformatRenderer
+		fd.VideoID = vd.VideoID
+		fd.Title = vd.Title
+		fd.Author = vd.Author
+		fd.ChannelID = vd.ChannelID
+		fd.UploadDate = mf.UploadDate
+		fd.PublishDate = mf.PublishDate
+		fd.Category = mf.Category
+		fd.Length = vd.LengthSeconds
+		fd.ViewCount = vd.ViewCount
+		fd.VideoDetails = vd
+		fd.Microformat = mf
+	}
+
+	for _, s := range []*string{
+		&fd.VideoID, &fd.Title, &fd.Author, &fd.ChannelID, &fd.UploadDate, &fd.PublishDate,
+		&fd.Category, &fd.Length, &fd.ViewCount, &fd.Quality, &fd.QualityLabel, &fd.Type, &fd.Ext,
+	} {
+		*s = escapeSeparators(*s)
+	}
+	return fd
+}
+
+//ExpandFilename executes filename template (text/template) with FilenameData of Format
+//
+//Returned filename may contain directories written in the template
+func (f *Format) ExpandFilename(tmpl string, audio *Format) (string, error) {
+	t, err := template.New("filename").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", e.DbgErr(err)
+	}
+
+	buf := new(bytes.Buffer)
+	err = t.Execute(buf, f.FilenameData(audio))
+	if err != nil {
+		return "", e.DbgErr(err)
+	}
+
+	name := filepath.Clean(filepath.FromSlash(buf.String()))
+	if filepath.IsAbs(name) || name == "." || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", e.DbgErr(e.New(e.ErrYtdl, "filename template must expand to relative path: "+name))
+	}
+	return name, nil
+}
+
+//Ext returns file extension of Format without dot
+func (f *Format) Ext() string {
+	if prop, ok := itagList[f.Itag]; ok {
+		return prop.FileType
+	}
+
+	typ, _, err := mime.ParseMediaType(f.MimeType)
+	if err != nil {
+		return ""
+	}
+	sp := strings.SplitN(typ, "/", 2)
+	if len(sp) != 2 {
+		return ""
+	}
+	if sp[0] == "audio" && sp[1] == mp4 {
+		return m4a
+	}
+	return sp[1]
+}
+
+func escapeSeparators(s string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(s)
+}
@@ -0,0 +1,82 @@
+package ytdl_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sam1677/ytdl"
+)
+
+const metadataPlayerResponse = `{
+	"playabilityStatus": {"status": "OK"},
+	"streamingData": {
+		"adaptiveFormats": [
+			{"itag": 137, "url": "https://example.com/videoplayback?itag=137", "mimeType": "video/mp4; codecs=\"avc1.640028\"", "qualityLabel": "1080p", "quality": "hd1080"},
+			{"itag": 251, "url": "https://example.com/videoplayback?itag=251", "mimeType": "audio/webm; codecs=\"opus\"", "quality": "tiny"}
+		]
+	},
+	"videoDetails": {"videoId": "9bZkp7q19f0", "title": "PSY - GANGNAM STYLE(강남스타일) M/V", "author": "officialpsy", "lengthSeconds": "253"},
+	"microformat": {"playerMicroformatRenderer": {"uploadDate": "2012-07-15", "category": "Music"}}
+}`
+
+func TestExpandFilename(t *testing.T) {
+	vi, err := ytdl.LoadVideoInfo(strings.NewReader(metadataPlayerResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	video := vi.StreamingData.AdaptiveFormats.Videos().First()
+	audio := vi.StreamingData.AdaptiveFormats.Audios().First()
+
+	name, err := video.ExpandFilename("{{.Author}}/{{.UploadDate}} - {{.Title}} [{{.VideoID}}] {{.Itag}}+{{.AudioItag}}.{{.Ext}}", audio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.FromSlash("officialpsy/2012-07-15 - PSY - GANGNAM STYLE(강남스타일) M_V [9bZkp7q19f0] 137+251.mp4")
+	if name != want {
+		t.Errorf("got %q, want %q", name, want)
+	}
+
+	if _, err := video.ExpandFilename("../{{.Title}}", nil); err == nil {
+		t.Error("template escaping download path is accepted")
+	}
+	if name, err := video.ExpandFilename("...And Justice for All {{.Itag}}.{{.Ext}}", nil); err != nil || name != "...And Justice for All 137.mp4" {
+		t.Errorf("title starting with dots: got %q, %v", name, err)
+	}
+	if _, err := video.ExpandFilename("..", nil); err == nil {
+		t.Error("template expanding to parent directory is accepted")
+	}
+	if _, err := video.ExpandFilename("{{.NoSuchField}}", nil); err == nil {
+		t.Error("template with unknown field is accepted")
+	}
+}
+
+func TestDownloadOptionsReused(t *testing.T) {
+	ts := newMediaServer()
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+
+	vi, err := c.GetVideoInfo("9bZkp7q19f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := &ytdl.DownloadOptions{FilenameTemplate: "{{.Title}}/{{.Itag}}.{{.Ext}}"}
+	for _, f := range vi.StreamingData.AdaptiveFormats {
+		err = f.Download(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if options.Path != "" || options.Filename != "" {
+		t.Errorf("options are modified: %+v", options)
+	}
+	for _, name := range []string{"137.mp4", "140.m4a"} {
+		if _, err := os.Stat(filepath.Join(dir, "Downloads", "PSY - GANGNAM STYLE", name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package ytdl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//InfoJSONVersion is version of InfoJSON written by this package
+//
+//It's incremented when fields are changed incompatibly
+const InfoJSONVersion = 1
+
+//infoJSONExt is appended to output filename without extension
+const infoJSONExt = ".info.json"
+
+//ErrInfoJSONVersion info.json is written by newer version
+var ErrInfoJSONVersion = e.New(e.ErrYtdl, "unsupported info.json version")
+
+//InfoJSON is normalized metadata written next to downloaded file
+//(DownloadOptions.WriteInfoJSON)
+type InfoJSON struct {
+	Version     int      `json:"version"`
+	VideoID     string   `json:"videoId"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Category    string   `json:"category,omitempty"`
+
+	Channel struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		URL  string `json:"url,omitempty"`
+	} `json:"channel"`
+
+	//UploadDate and PublishDate are YYYY-MM-DD
+	UploadDate      string `json:"uploadDate,omitempty"`
+	PublishDate     string `json:"publishDate,omitempty"`
+	DurationSeconds int    `json:"durationSeconds"`
+	ViewCount       int64  `json:"viewCount"`
+	IsLive          bool   `json:"isLive"`
+
+	//Formats are downloaded Formats (video first)
+	Formats    []InfoFormat    `json:"formats"`
+	Chapters   []Chapter       `json:"chapters,omitempty"`
+	Thumbnails []InfoThumbnail `json:"thumbnails,omitempty"`
+
+	//Filename is output filename relative to info.json
+	Filename  string    `json:"filename,omitempty"`
+	WrittenAt time.Time `json:"writtenAt"`
+}
+
+//InfoFormat describes downloaded Format in InfoJSON
+type InfoFormat struct {
+	Itag          int    `json:"itag"`
+	Type          string `json:"type"`
+	MimeType      string `json:"mimeType"`
+	Ext           string `json:"ext"`
+	Quality       string `json:"quality,omitempty"`
+	QualityLabel  string `json:"qualityLabel,omitempty"`
+	Width         int    `json:"width,omitempty"`
+	Height        int    `json:"height,omitempty"`
+	FPS           int    `json:"fps,omitempty"`
+	Bitrate       int    `json:"bitrate"`
+	ContentLength int64  `json:"contentLength,omitempty"`
+	SampleRate    int    `json:"audioSampleRate,omitempty"`
+	Channels      int    `json:"audioChannels,omitempty"`
+}
+
+//InfoThumbnail describes thumbnail in InfoJSON
+type InfoThumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+//Chapter is chapter of video parsed from timestamps in description
+type Chapter struct {
+	StartSeconds float64 `json:"startSeconds"`
+	EndSeconds   float64 `json:"endSeconds"`
+	Title        string  `json:"title"`
+}
+
+//InfoJSON returns normalized metadata of VideoInfo with downloaded formats
+func (vi *VideoInfo) InfoJSON(formats ...*Format) *InfoJSON {
+	vd := &vi.VideoDetails
+	mf := &vi.Microformat.PlayerMicroformatRenderer
+
+	info := &InfoJSON{
+		Version:     InfoJSONVersion,
+		VideoID:     vd.VideoID,
+		Title:       vd.Title,
+		Description: vd.ShortDescription,
+		Keywords:    vd.Keywords,
+		Category:    mf.Category,
+		UploadDate:  mf.UploadDate,
+		PublishDate: mf.PublishDate,
+		IsLive:      vd.IsLiveContent,
+		WrittenAt:   time.Now(),
+	}
+	if info.Description == "" {
+		info.Description = mf.Description.String()
+	}
+	info.Channel.ID = vd.ChannelID
+	info.Channel.Name = vd.Author
+	info.Channel.URL = mf.OwnerProfileURL
+	info.DurationSeconds, _ = strconv.Atoi(vd.LengthSeconds)
+	info.ViewCount, _ = strconv.ParseInt(vd.ViewCount, 10, 64)
+
+	for _, f := range formats {
+		if f == nil {
+			continue
+		}
+		inf := InfoFormat{
+			Itag:         f.Itag,
+			Type:         f.ItagProp.ContentType.String(),
+			MimeType:     f.MimeType,
+			Ext:          f.Ext(),
+			Quality:      f.Quality,
+			QualityLabel: f.QualityLabel,
+			Width:        f.Width,
+			Height:       f.Height,
+			FPS:          f.FPS,
+			Bitrate:      f.Bitrate,
+			Channels:     f.AudioChannels,
+		}
+		inf.ContentLength, _ = strconv.ParseInt(f.ContentLength, 10, 64)
+		inf.SampleRate, _ = strconv.Atoi(f.AudioSampleRate)
+		info.Formats = append(info.Formats, inf)
+	}
+
+	for _, th := range vd.Thumbnail.Thumbnails {
+		info.Thumbnails = append(info.Thumbnails, InfoThumbnail{URL: th.URL, Width: th.Width, Height: th.Height})
+	}
+	info.Chapters = parseChapters(info.Description, float64(info.DurationSeconds))
+	return info
+}
+
+//LoadInfoJSON reads info.json written by DownloadOptions.WriteInfoJSON
+func LoadInfoJSON(r io.Reader) (*InfoJSON, error) {
+	info := new(InfoJSON)
+	err := json.NewDecoder(r).Decode(info)
+	if err != nil {
+		return nil, e.Wrap(err, "LoadInfoJSON", "", 0)
+	}
+	if info.Version < 1 || info.Version > InfoJSONVersion {
+		return nil, e.Wrap(fmt.Errorf("%w: %d", ErrInfoJSONVersion, info.Version), "LoadInfoJSON", info.VideoID, 0)
+	}
+	return info, nil
+}
+
+//writeInfoJSON writes info.json next to path/filename
+func (vi *VideoInfo) writeInfoJSON(path, filename string, formats ...*Format) error {
+	info := vi.InfoJSON(formats...)
+	info.Filename = filename
+
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	name := strings.TrimSuffix(filename, filepath.Ext(filename)) + infoJSONExt
+	return e.DbgErr(ioutil.WriteFile(filepath.Join(path, name), data, 0644))
+}
+
+var chapterLine = regexp.MustCompile(`^\s*(?:[-•*▶]\s*)?\(?((?:\d+:)?\d{1,2}:\d{2})\)?\s*[-–—:|.]?\s*(.+)$`)
+
+//parseChapters parses chapters from timestamped lines in description
+//
+//Like Youtube, chapters are recognized only if the first timestamp is 0:00
+//and there are at least 2 of them
+func parseChapters(description string, duration float64) []Chapter {
+	chapters := []Chapter{}
+	for _, line := range strings.Split(description, "\n") {
+		m := chapterLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		start := parseTimestamp(m[1])
+		if len(chapters) > 0 && start <= chapters[len(chapters)-1].StartSeconds {
+			continue
+		}
+		chapters = append(chapters, Chapter{StartSeconds: start, Title: strings.TrimSpace(m[2])})
+	}
+
+	if len(chapters) < 2 || chapters[0].StartSeconds != 0 {
+		return nil
+	}
+	for i := range chapters {
+		if i+1 < len(chapters) {
+			chapters[i].EndSeconds = chapters[i+1].StartSeconds
+		} else {
+			chapters[i].EndSeconds = duration
+		}
+	}
+	return chapters
+}
+
+//parseTimestamp parses [h:]m:ss into seconds
+func parseTimestamp(ts string) float64 {
+	sec := 0
+	for _, part := range strings.Split(ts, ":") {
+		n, _ := strconv.Atoi(part)
+		sec = sec*60 + n
+	}
+	return float64(sec)
+}
@@ -0,0 +1,80 @@
+package ytdl_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sam1677/ytdl"
+)
+
+const chaptersPlayerResponse = `{
+	"playabilityStatus": {"status": "OK"},
+	"videoDetails": {"videoId": "9bZkp7q19f0", "title": "title", "lengthSeconds": "300",
+		"shortDescription": "Tracklist\n0:00 Intro\n1:05 - First song\n(2:30) Second song\nnot 3:00 a chapter\n4:10 Outro"}
+}`
+
+func TestInfoJSONChapters(t *testing.T) {
+	vi, err := ytdl.LoadVideoInfo(strings.NewReader(chaptersPlayerResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ytdl.Chapter{
+		{StartSeconds: 0, EndSeconds: 65, Title: "Intro"},
+		{StartSeconds: 65, EndSeconds: 150, Title: "First song"},
+		{StartSeconds: 150, EndSeconds: 250, Title: "Second song"},
+		{StartSeconds: 250, EndSeconds: 300, Title: "Outro"},
+	}
+	got := vi.InfoJSON().Chapters
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("chapter %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWriteInfoJSON(t *testing.T) {
+	ts := newMediaServer()
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+
+	vi, err := c.GetVideoInfo("9bZkp7q19f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video := vi.StreamingData.AdaptiveFormats.Videos().Best()
+	err = video.Download(&ytdl.DownloadOptions{Filename: "video.mp4", WriteInfoJSON: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(filepath.Join(dir, "Downloads", "video.info.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	info, err := ytdl.LoadInfoJSON(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != ytdl.InfoJSONVersion || info.VideoID != "9bZkp7q19f0" || info.Filename != "video.mp4" {
+		t.Errorf("unexpected info.json %+v", info)
+	}
+	if len(info.Formats) != 1 || info.Formats[0].Itag != video.Itag || info.Formats[0].Ext != "mp4" {
+		t.Errorf("unexpected formats %+v", info.Formats)
+	}
+
+	_, err = ytdl.LoadInfoJSON(strings.NewReader(`{"version": 1000}`))
+	if !errors.Is(err, ytdl.ErrInfoJSONVersion) {
+		t.Errorf("newer version is loaded: %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package ytdl
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/sam1677/ytdl/internal/logger"
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//Selector picks video Format to download and audio Format to merge (nil if not needed)
+type Selector func(vi *VideoInfo) (video, audio *Format, err error)
+
+//ErrNoFormat Selector found no Format to download
+var ErrNoFormat = e.New(e.ErrYtdl, "no format to download")
+
+//SelectBest selects best adaptive video and best adaptive audio,
+//or best Format with both video and audio if there are no adaptive Formats
+func SelectBest(vi *VideoInfo) (video, audio *Format, err error) {
+	video = vi.StreamingData.AdaptiveFormats.Videos().Best()
+	audio = vi.StreamingData.AdaptiveFormats.Audios().Best()
+	if video != nil && audio != nil {
+		return video, audio, nil
+	}
+
+	video = vi.StreamingData.Formats.Best()
+	if video == nil {
+		return nil, nil, ErrNoFormat
+	}
+	return video, nil, nil
+}
+
+//Job describes a video to download
+type Job struct {
+	//VideoID is video ID or URL
+	VideoID string
+	//Select picks Formats to download (default: SelectBest)
+	Select Selector
+	//Options of Download, AudioOverride is set by Select
+	Options *DownloadOptions
+}
+
+//JobState is state of Job
+type JobState int
+
+const (
+	//JobPending Job is waiting for a worker
+	JobPending JobState = iota
+	//JobFetching worker is getting VideoInfo
+	JobFetching
+	//JobDownloading worker is downloading Formats
+	JobDownloading
+	//JobDone Job succeeded
+	JobDone
+	//JobFailed Job failed, JobStatus.Err describes why
+	JobFailed
+	//JobSkipped video is already in Archive or output file exists (see CollisionPolicy)
+	JobSkipped
+)
+
+func (s JobState) String() string {
+	switch s {
+	case JobPending:
+		return "pending"
+	case JobFetching:
+		return "fetching"
+	case JobDownloading:
+		return "downloading"
+	case JobDone:
+		return "done"
+	case JobFailed:
+		return "failed"
+	case JobSkipped:
+		return "skipped"
+	}
+	return ""
+}
+
+//JobStatus describes state of Job
+type JobStatus struct {
+	//Index is index of Job in jobs given to Manager.Run
+	Index     int
+	Job       Job
+	State     JobState
+	VideoInfo *VideoInfo
+	Video     *Format
+	Audio     *Format
+	Err       error
+}
+
+//Results describes results of Manager.Run
+type Results struct {
+	//Jobs are final statuses in order of jobs given to Manager.Run
+	Jobs      []JobStatus
+	Succeeded int
+	Failed    int
+	Skipped   int
+}
+
+//Errors returns errors of failed Jobs
+func (r *Results) Errors() []error {
+	ret := []error{}
+	for _, js := range r.Jobs {
+		if js.Err != nil {
+			ret = append(ret, js.Err)
+		}
+	}
+	return ret
+}
+
+//defaultWorkers is used if Manager.Workers is 0
+const defaultWorkers = 4
+
+//Manager downloads many videos concurrently with a bounded worker pool
+type Manager struct {
+	//Client gets VideoInfo and downloads Formats (default: DefaultClient)
+	Client *Client
+	//Workers is number of Jobs processed at once (default: 4)
+	Workers int
+	//OnStatus is called whenever state of a Job changes
+	//
+	//Calls are serialized, so OnStatus doesn't need to be safe for concurrent use
+	OnStatus func(JobStatus)
+
+	statusMu sync.Mutex
+}
+
+//Run processes jobs and blocks until all of them are done or failed
+func (m *Manager) Run(jobs []Job) *Results {
+	c := m.Client
+	if c == nil {
+		c = DefaultClient
+	}
+	workers := m.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+
+	results := &Results{Jobs: make([]JobStatus, len(jobs))}
+	queue := make(chan int)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ind := range queue {
+				results.Jobs[ind] = m.runJob(c, ind, jobs[ind])
+			}
+		}()
+	}
+
+	for ind, job := range jobs {
+		m.report(JobStatus{Index: ind, Job: job, State: JobPending})
+	}
+	for ind := range jobs {
+		queue <- ind
+	}
+	close(queue)
+	wg.Wait()
+
+	for _, js := range results.Jobs {
+		switch js.State {
+		case JobDone:
+			results.Succeeded++
+		case JobSkipped:
+			results.Skipped++
+		default:
+			results.Failed++
+		}
+	}
+	logger.Info(c.Logger, "jobs finished",
+		"succeeded", results.Succeeded, "failed", results.Failed, "skipped", results.Skipped)
+	return results
+}
+
+func (m *Manager) runJob(c *Client, ind int, job Job) JobStatus {
+	js := JobStatus{Index: ind, Job: job, State: JobFetching}
+	m.report(js)
+
+	fail := func(err error) JobStatus {
+		js.State = JobFailed
+		js.Err = err
+		logger.Warn(c.Logger, "job failed", "index", ind, "videoID", job.VideoID, "err", err)
+		m.report(js)
+		return js
+	}
+
+	// archive is consulted before fetching so repeated runs don't request anything
+	if VID, err := getVideoIDFromURL(job.VideoID); err == nil {
+		ok, err := c.archived(job.Options, VID)
+		if err != nil {
+			return fail(e.Wrap(err, "Archive", VID, 0))
+		}
+		if ok {
+			js.State = JobSkipped
+			m.report(js)
+			return js
+		}
+	}
+
+	vi, err := c.GetVideoInfo(job.VideoID)
+	if err != nil {
+		return fail(err)
+	}
+	js.VideoInfo = vi
+
+	sel := job.Select
+	if sel == nil {
+		sel = SelectBest
+	}
+	js.Video, js.Audio, err = sel(vi)
+	if err == nil && js.Video == nil {
+		err = ErrNoFormat
+	}
+	if err != nil {
+		return fail(e.Wrap(err, "Select", vi.videoID(), 0))
+	}
+
+	// options are copied since AudioOverride is set per job
+	options := new(DownloadOptions)
+	if job.Options != nil {
+		*options = *job.Options
+	}
+	options.AudioOverride = js.Audio
+
+	js.State = JobDownloading
+	m.report(js)
+
+	err = js.Video.download(options)
+	if errors.Is(err, errSkipped) {
+		js.State = JobSkipped
+		m.report(js)
+		return js
+	}
+	if err != nil {
+		return fail(e.Wrap(err, "Download", vi.videoID(), js.Video.Itag))
+	}
+
+	js.State = JobDone
+	m.report(js)
+	return js
+}
+
+func (m *Manager) report(js JobStatus) {
+	if m.OnStatus == nil {
+		return
+	}
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	m.OnStatus(js)
+}
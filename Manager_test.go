@@ -0,0 +1,166 @@
+package ytdl_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sam1677/ytdl"
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//newMediaServer serves "<itag>-media" for every stream URL
+func newMediaServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Query().Get("itag") + "-media"))
+	}))
+}
+
+//newCachedClient creates offline Client which has player response of videoID cached
+func newCachedClient(t *testing.T, videoID, mediaURL string) (*ytdl.Client, string) {
+	dir, err := ioutil.TempDir("", "ytdl-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	playerResponse := strings.NewReplacer(
+		"https://example.com", mediaURL,
+		"&expire=1", "",
+		"9bZkp7q19f0", videoID,
+	).Replace(cachedPlayerResponse)
+
+	err = os.MkdirAll(filepath.Join(dir, "jsonCache"), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "jsonCache", videoID+".json"), []byte(playerResponse), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ytdl.Client{CacheDir: dir, Offline: true, DownloadDir: filepath.Join(dir, "Downloads")}, dir
+}
+
+func TestManager(t *testing.T) {
+	ts := newMediaServer()
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+
+	videoOnly := func(vi *ytdl.VideoInfo) (*ytdl.Format, *ytdl.Format, error) {
+		return vi.StreamingData.AdaptiveFormats.Videos().Best(), nil, nil
+	}
+
+	states := map[int][]ytdl.JobState{}
+	m := &ytdl.Manager{Client: c, Workers: 2, OnStatus: func(js ytdl.JobStatus) {
+		states[js.Index] = append(states[js.Index], js.State)
+	}}
+	results := m.Run([]ytdl.Job{
+		{VideoID: "9bZkp7q19f0", Select: videoOnly},
+		{VideoID: "notCached00", Select: videoOnly},
+	})
+
+	if results.Succeeded != 1 || results.Failed != 1 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if !errors.Is(results.Jobs[1].Err, e.ErrCacheMiss) {
+		t.Errorf("job 1 failed with %v", results.Jobs[1].Err)
+	}
+	if got := states[0]; len(got) != 4 || got[3] != ytdl.JobDone {
+		t.Errorf("job 0 went through %v", got)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "Downloads", "9bZkp7q19f0-1080p-hd1080.mp4"))
+	if err != nil || string(data) != "137-media" {
+		t.Errorf("downloaded %q, %v", data, err)
+	}
+}
+
+func TestManagerArchive(t *testing.T) {
+	ts := newMediaServer()
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+
+	archive, err := ytdl.OpenArchive(filepath.Join(dir, "archive.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Archive = archive
+
+	jobs := []ytdl.Job{{VideoID: "https://youtu.be/9bZkp7q19f0", Select: func(vi *ytdl.VideoInfo) (*ytdl.Format, *ytdl.Format, error) {
+		return vi.StreamingData.AdaptiveFormats.Videos().Best(), nil, nil
+	}}}
+
+	m := &ytdl.Manager{Client: c}
+	if results := m.Run(jobs); results.Succeeded != 1 {
+		t.Fatalf("first run: %+v", results)
+	}
+
+	reopened, err := ytdl.OpenArchive(filepath.Join(dir, "archive.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := reopened.Entry("9bZkp7q19f0"); !ok || len(entry.Itags) != 1 || entry.Itags[0] != 137 {
+		t.Errorf("archive entry = %+v, %v", entry, ok)
+	}
+
+	c.Archive = reopened
+	if results := m.Run(jobs); results.Skipped != 1 {
+		t.Errorf("second run: %+v", results)
+	}
+}
+
+func TestManagerSkipExisting(t *testing.T) {
+	ts := newMediaServer()
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+
+	jobs := []ytdl.Job{{
+		VideoID: "9bZkp7q19f0",
+		Options: &ytdl.DownloadOptions{OnCollision: ytdl.Skip},
+		Select: func(vi *ytdl.VideoInfo) (*ytdl.Format, *ytdl.Format, error) {
+			return vi.StreamingData.AdaptiveFormats.Videos().Best(), nil, nil
+		},
+	}}
+
+	m := &ytdl.Manager{Client: c}
+	if results := m.Run(jobs); results.Succeeded != 1 {
+		t.Fatalf("first run: %+v", results)
+	}
+	if results := m.Run(jobs); results.Skipped != 1 || results.Jobs[0].State != ytdl.JobSkipped {
+		t.Errorf("second run: %+v", results)
+	}
+}
+
+func TestFileArchiveLiteral(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ytdl-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "archive.txt")
+	err = ioutil.WriteFile(filename, []byte("youtube 9bZkp7q19f0 137+140\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &ytdl.FileArchive{Filename: filename}
+	if ok, err := a.Has("9bZkp7q19f0"); !ok || err != nil {
+		t.Errorf("Has = %v, %v", ok, err)
+	}
+	if err := a.Add(ytdl.ArchiveEntry{VideoID: "notCached00", Itags: []int{18}}); err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := (&ytdl.FileArchive{Filename: filename}).Entry("notCached00"); !ok || entry.Itags[0] != 18 {
+		t.Errorf("Entry = %+v, %v", entry, ok)
+	}
+}
@@ -1,99 +1,144 @@
 package ytdl
 
 import (
-	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"net/url"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
 
-	u "github.com/sam1677/ytdl/internal/utils"
-	e "github.com/sam1677/ytdl/internal/ytdlerrors"
+	"github.com/sam1677/ytdl/internal/logger"
+	e "github.com/sam1677/ytdl/ytdlerrors"
 )
 
 //decipherFormat deciphers given Format
 func (vi *VideoInfo) decipherFormat(f *Format) error {
-	_, data, err := u.DownloadFile(vi.Microformat.PlayerMicroformatRenderer.Embed.IframeURL, "", "", true)
+	cip, err := vi.client.getDecipherer(vi)
 	if err != nil {
 		return e.DbgErr(err)
 	}
 
-	err = u.CreatePath(tmpScriptDir)
+	query, err := url.ParseQuery(f.SignatureCipher)
 	if err != nil {
 		return e.DbgErr(err)
 	}
 
-	err = ioutil.WriteFile(u.MergePathAndFilename(tmpScriptDir, "outerHtml.html"), data, 0755)
+	signature := query.Get("s")
+	sigKey := query.Get("sp")
+	cipheredURL := query.Get("url")
+
+	URL, err := url.Parse(cipheredURL)
 	if err != nil {
 		return e.DbgErr(err)
 	}
 
-	//r := regexp.MustCompile(`<\s*script[^<>]*name\s*=\s*"player_ias/base"[^<>]*>[^<>]*<\s*/script\s*>`)
-	//r1 := regexp.MustCompile(`src="([^" ]*\.js)"`)
-
-	//matched := r.FindStringSubmatch(string(data))
-	//if len(matched) == 0 {
-	//	return errors.New("An Error Occured on Loading Script")
-	//}
-
-	//scriptStr := matched[0]
-	//scriptSrc := r1.FindStringSubmatch(scriptStr)[1]
-	//scriptURL := "https://www.youtube.com" + scriptSrc
+	val := URL.Query()
 
-	scriptSrc, err := regexpSearch(`"(\/[^"<>]*\/base.js)"`, string(data), 1)
+	sig, err := cip.GetSignature(signature)
 	if err != nil {
-		return err
+		return e.DbgErr(err)
 	}
+	f.SignatureCipher = ""
 
-	scriptURL := "https://www.youtube.com" + scriptSrc
+	val.Add(sigKey, sig)
+	URL.RawQuery = val.Encode()
 
-	_, data, err = u.DownloadFile(scriptURL, "", "", true)
-	if err != nil {
-		return e.DbgErr(err)
+	f.URL = URL.String()
+
+	return nil
+}
+
+//getDecipherer returns decipherer made from latest player script
+//
+//player script is downloaded once per Client (and again by checkPlayer if it changed),
+//concurrent callers wait until it's done
+func (c *Client) getDecipherer(vi *VideoInfo) (*decipherer, error) {
+	c.cipherMu.Lock()
+	defer c.cipherMu.Unlock()
+
+	if c.cipher != nil { // if executed this session return latest cipher
+		return c.cipher, nil
 	}
 
-	err = ioutil.WriteFile(u.MergePathAndFilename(tmpScriptDir, "script.js"), data, 0755)
-	if err != nil {
-		return e.DbgErr(err)
+	if c.Offline {
+		data, err := c.loadCachedScript()
+		if err != nil {
+			return nil, e.DbgErr(err)
+		}
+		c.cipher, err = newDecipherer(string(data), c.Logger)
+		return c.cipher, e.DbgErr(err)
 	}
 
-	query, err := url.ParseQuery(f.SignatureCipher)
+	scriptURL, err := c.playerScriptURL(vi)
 	if err != nil {
-		return e.DbgErr(err)
+		return nil, e.DbgErr(err)
 	}
+	return c.loadDecipherer(vi, scriptURL)
+}
 
-	signature := query.Get("s")
-	sigKey := query.Get("sp")
-	cipheredURL := query.Get("url")
+//checkPlayer reloads decipherer if Youtube changed player script since it was loaded
+//
+//Stream URLs deciphered with old player script get 403, so it's called before refreshing them
+func (c *Client) checkPlayer(vi *VideoInfo) error {
+	c.cipherMu.Lock()
+	defer c.cipherMu.Unlock()
 
-	URL, err := url.Parse(cipheredURL)
+	if c.cipher == nil || c.Offline {
+		return nil
+	}
+
+	scriptURL, err := c.playerScriptURL(vi)
 	if err != nil {
 		return e.DbgErr(err)
 	}
+	if scriptURL == c.cipherURL {
+		return nil
+	}
+	logger.Info(c.Logger, "player script changed", "old", c.cipherURL, "new", scriptURL)
+	_, err = c.loadDecipherer(vi, scriptURL)
+	return e.DbgErr(err)
+}
 
-	val := URL.Query()
+//playerScriptURL returns URL of player script used by embed page of video
+func (c *Client) playerScriptURL(vi *VideoInfo) (string, error) {
+	d := c.downloader(vi.videoID())
+	_, data, err := d.DownloadFile(vi.Microformat.PlayerMicroformatRenderer.Embed.IframeURL, "", "", true)
+	if err != nil {
+		return "", e.DbgErr(err)
+	}
 
-	cip, err := newDecipherer(string(data))
+	err = c.cacheFile(scriptCacheDir, "outerHtml.html", data)
 	if err != nil {
-		return e.DbgErr(err)
+		return "", e.DbgErr(err)
 	}
 
-	sig, err := cip.GetSignature(signature)
+	scriptSrc, err := regexpSearch(`"(\/[^"<>]*\/base.js)"`, string(data), 1)
 	if err != nil {
-		return e.DbgErr(err)
+		return "", e.DbgErr(err)
 	}
-	f.SignatureCipher = ""
+	return "https://www.youtube.com" + scriptSrc, nil
+}
 
-	val.Add(sigKey, sig)
-	URL.RawQuery = val.Encode()
+//loadDecipherer downloads player script of scriptURL and makes decipherer of Client from it
+//
+//cipherMu must be held
+func (c *Client) loadDecipherer(vi *VideoInfo, scriptURL string) (*decipherer, error) {
+	_, data, err := c.downloader(vi.videoID()).DownloadFile(scriptURL, "", "", true)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
 
-	f.URL = URL.String()
+	err = c.cacheFile(scriptCacheDir, "script.js", data)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
 
-	return nil
+	cipher, err := newDecipherer(string(data), c.Logger)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	c.cipher, c.cipherURL = cipher, scriptURL
+	return c.cipher, nil
 }
 
 //decipherAll executes DecipherFormat for all Format in the VideoInfo
@@ -145,28 +190,20 @@ type decipherer struct {
 	TransformMap    map[string]interface{}
 	js              string
 	noIndentJS      string
-	logger          *log.Logger
+	logger          logger.Logger
 }
 
-var latestCipher *decipherer
-
 //newDecipherer makes cipher
-func newDecipherer(js string) (*decipherer, error) {
-	if latestCipher != nil { // if executed this session return latest cipher
-		return latestCipher, nil
-	}
+//
+//every step is traced to l in LevelDebug
+func newDecipherer(js string, l logger.Logger) (*decipherer, error) {
 	if js == "" {
 		return nil, e.DbgErr(e.ErrJSstrIsEmpty)
 	}
 
+	var err error
 	c := new(decipherer)
-	//Create Log File
-	logFile, err := os.OpenFile("Cipher.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0755)
-	if err != nil {
-		return nil, e.DbgErr(err)
-	}
-
-	c.logger = log.New(logFile, "", log.Ltime|log.Lmicroseconds)
+	c.logger = l
 	c.js = js
 	c.noIndentJS = strings.ReplaceAll(js, "\n", " ")
 
@@ -189,8 +226,6 @@ func newDecipherer(js string) (*decipherer, error) {
 		return nil, e.DbgErr(err)
 	}
 
-	latestCipher = c
-
 	return c, nil
 }
 
@@ -209,10 +244,10 @@ func (c *decipherer) GetSignature(cipheredSignature string) (string, error) {
 
 		fun, ex := c.TransformMap[name]
 		if !ex {
-			return "", e.DbgErr(errors.New(name + "'s function is not found"))
+			return "", e.DbgErr(fmt.Errorf("%w: %s", e.ErrFunctionNotFound, name))
 		}
 		if fun == nil {
-			return "", e.DbgErr(errors.New("fun is nil : " + name))
+			return "", e.DbgErr(fmt.Errorf("%w: %s is nil", e.ErrFunctionNotFound, name))
 		}
 
 		signature = fun.(func(args ...interface{}) []string)(signature, argument)
@@ -342,7 +377,7 @@ func (c *decipherer) getTransformMap(firstFuncName string) (ret map[string]inter
 		t := strings.SplitN(obj, ":", 2)
 		c.log(t)
 		if len(t) < 2 {
-			return nil, errors.New("Function count is less than 2")
+			return nil, e.DbgErr(fmt.Errorf("%w: %s", e.ErrFuncListIsTooShort, obj))
 		}
 
 		funcName := t[0]
@@ -387,10 +422,14 @@ func (c *decipherer) mapFunctions(jsFunc string) (interface{}, error) {
 // Utils
 
 func (c *decipherer) log(v ...interface{}) {
-	c.logger.Print(v...)
+	if c.logger != nil {
+		logger.Debug(c.logger, fmt.Sprint(v...))
+	}
 }
 func (c *decipherer) logf(format string, v ...interface{}) {
-	c.logger.Printf(format, v...)
+	if c.logger != nil {
+		logger.Debug(c.logger, fmt.Sprintf(format, v...))
+	}
 }
 
 // Returns matched groups[group] group 0=> full 1=> first .....
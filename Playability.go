@@ -0,0 +1,200 @@
+package ytdl
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//Reasons of unplayable video (see ytdlerrors package)
+var (
+	//ErrVideoPrivate video is private
+	ErrVideoPrivate = e.ErrVideoPrivate
+	//ErrAgeRestricted video requires age verification
+	ErrAgeRestricted = e.ErrAgeRestricted
+	//ErrLoginRequired video requires signing in
+	ErrLoginRequired = e.ErrLoginRequired
+	//ErrGeoBlocked video is not available in this country
+	ErrGeoBlocked = e.ErrGeoBlocked
+	//ErrVideoUnavailable video is removed, does not exist or is unplayable
+	ErrVideoUnavailable = e.ErrVideoUnavailable
+	//ErrLiveNotStarted live stream or premiere has not started yet
+	ErrLiveNotStarted = e.ErrLiveNotStarted
+)
+
+//Playability statuses sent by Youtube
+const (
+	StatusOK                      = "OK"
+	StatusError                   = "ERROR"
+	StatusUnplayable              = "UNPLAYABLE"
+	StatusLoginRequired           = "LOGIN_REQUIRED"
+	StatusLiveStreamOffline       = "LIVE_STREAM_OFFLINE"
+	StatusAgeCheckRequired        = "AGE_CHECK_REQUIRED"
+	StatusAgeVerificationRequired = "AGE_VERIFICATION_REQUIRED"
+	StatusContentCheckRequired    = "CONTENT_CHECK_REQUIRED"
+)
+
+//PlayabilityStatus describes playabilityStatus JSON type
+type PlayabilityStatus struct {
+	Status          string   `json:"status"`
+	Reason          string   `json:"reason,omitempty"`
+	Messages        []string `json:"messages,omitempty"`
+	PlayableInEmbed bool     `json:"playableInEmbed"`
+
+	ErrorScreen struct {
+		PlayerErrorMessageRenderer struct {
+			Reason    SimpleText `json:"reason"`
+			Subreason SimpleText `json:"subreason"`
+		} `json:"playerErrorMessageRenderer"`
+	} `json:"errorScreen"`
+
+	LiveStreamability struct {
+		LiveStreamabilityRenderer struct {
+			VideoID      string `json:"videoId"`
+			PollDelayMs  string `json:"pollDelayMs"`
+			OfflineSlate struct {
+				LiveStreamOfflineSlateRenderer struct {
+					ScheduledStartTime string     `json:"scheduledStartTime"`
+					MainText           SimpleText `json:"mainText"`
+				} `json:"liveStreamOfflineSlateRenderer"`
+			} `json:"offlineSlate"`
+		} `json:"liveStreamabilityRenderer"`
+	} `json:"liveStreamability"`
+}
+
+//PlayabilityError is returned when Youtube refuses to play the video
+//
+//Err is one of ErrVideoPrivate, ErrAgeRestricted, ErrLoginRequired,
+//ErrGeoBlocked, ErrVideoUnavailable or ErrLiveNotStarted
+type PlayabilityError struct {
+	Status    string
+	Reason    string
+	Subreason string
+	Messages  []string
+	//ScheduledStart is set when Err is ErrLiveNotStarted and Youtube sent the start time
+	ScheduledStart time.Time
+	Err            error
+}
+
+func (pe *PlayabilityError) Error() string {
+	msg := pe.Err.Error() + " (" + pe.Status
+	if pe.Reason != "" {
+		msg += ": " + pe.Reason
+	}
+	if pe.Subreason != "" {
+		msg += " " + pe.Subreason
+	}
+	return msg + ")"
+}
+
+//Unwrap returns the reason sentinel error
+func (pe *PlayabilityError) Unwrap() error {
+	return pe.Err
+}
+
+//reasons returns the reason and messages joined in lower case
+func (ps *PlayabilityStatus) reasons() string {
+	texts := []string{
+		ps.Reason,
+		ps.ErrorScreen.PlayerErrorMessageRenderer.Reason.String(),
+		ps.ErrorScreen.PlayerErrorMessageRenderer.Subreason.String(),
+	}
+	texts = append(texts, ps.Messages...)
+	return strings.ToLower(strings.Join(texts, " "))
+}
+
+//IsLive reports whether playabilityStatus contains live stream informations
+func (ps *PlayabilityStatus) IsLive() bool {
+	return ps.LiveStreamability.LiveStreamabilityRenderer.VideoID != ""
+}
+
+//Err returns *PlayabilityError if video is not playable and nil if it is
+func (ps *PlayabilityStatus) Err() error {
+	if ps.Status == StatusOK {
+		return nil
+	}
+
+	reason := ps.Reason
+	if reason == "" {
+		reason = ps.ErrorScreen.PlayerErrorMessageRenderer.Reason.String()
+	}
+	pe := &PlayabilityError{
+		Status:    ps.Status,
+		Reason:    reason,
+		Subreason: ps.ErrorScreen.PlayerErrorMessageRenderer.Subreason.String(),
+		Messages:  ps.Messages,
+	}
+
+	reasons := ps.reasons()
+	switch ps.Status {
+	case StatusLoginRequired:
+		switch {
+		case strings.Contains(reasons, "private"):
+			pe.Err = ErrVideoPrivate
+		case containsAny(reasons, ageRestrictedHints...):
+			pe.Err = ErrAgeRestricted
+		default:
+			pe.Err = ErrLoginRequired
+		}
+	case StatusAgeCheckRequired, StatusAgeVerificationRequired, StatusContentCheckRequired:
+		pe.Err = ErrAgeRestricted
+	case StatusLiveStreamOffline:
+		pe.Err = ErrLiveNotStarted
+	case StatusUnplayable:
+		switch {
+		case containsAny(reasons, "country", "region"):
+			pe.Err = ErrGeoBlocked
+		case ps.IsLive():
+			pe.Err = ErrLiveNotStarted
+		case strings.Contains(reasons, "private"):
+			pe.Err = ErrVideoPrivate
+		default:
+			pe.Err = ErrVideoUnavailable
+		}
+	default:
+		pe.Err = ErrVideoUnavailable
+	}
+
+	if pe.Err == ErrLiveNotStarted {
+		slate := ps.LiveStreamability.LiveStreamabilityRenderer.OfflineSlate.LiveStreamOfflineSlateRenderer
+		if sec, err := strconv.ParseInt(slate.ScheduledStartTime, 10, 64); err == nil {
+			pe.ScheduledStart = time.Unix(sec, 0)
+		}
+		if pe.Reason == "" {
+			pe.Reason = slate.MainText.String()
+		}
+	}
+	return pe
+}
+
+var ageRestrictedHints = []string{"confirm your age", "age-restricted", "inappropriate for some users"}
+
+func containsAny(s string, substrs ...string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+//unexpectedResponseErr builds error from get_video_info response without player_response
+func unexpectedResponseErr(data []byte) error {
+	query, err := url.ParseQuery(string(data))
+	if err != nil {
+		return &PlayabilityError{Status: "UNKNOWN", Err: ErrVideoUnavailable}
+	}
+
+	status := strings.ToUpper(query.Get("status"))
+	if status == "" {
+		status = "UNKNOWN"
+	}
+	reason := query.Get("reason")
+	if code := query.Get("errorcode"); code != "" {
+		reason = strings.TrimSpace(reason + " (errorcode " + code + ")")
+	}
+	return &PlayabilityError{Status: status, Reason: reason, Err: ErrVideoUnavailable}
+}
@@ -0,0 +1,45 @@
+package ytdl_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/sam1677/ytdl"
+)
+
+func TestPlayabilityStatusErr(t *testing.T) {
+	cases := []struct {
+		json string
+		want error
+	}{
+		{`{"status":"OK"}`, nil},
+		{`{"status":"LOGIN_REQUIRED","reason":"This video is private."}`, ytdl.ErrVideoPrivate},
+		{`{"status":"LOGIN_REQUIRED","errorScreen":{"playerErrorMessageRenderer":{"reason":{"simpleText":"Sign in to confirm your age"}}}}`, ytdl.ErrAgeRestricted},
+		{`{"status":"LOGIN_REQUIRED","reason":"Sign in to view this video"}`, ytdl.ErrLoginRequired},
+		{`{"status":"UNPLAYABLE","errorScreen":{"playerErrorMessageRenderer":{"subreason":{"runs":[{"text":"The uploader has not made this video available in your "},{"text":"country"}]}}}}`, ytdl.ErrGeoBlocked},
+		{`{"status":"ERROR","reason":"Video unavailable"}`, ytdl.ErrVideoUnavailable},
+		{`{"status":"LIVE_STREAM_OFFLINE","liveStreamability":{"liveStreamabilityRenderer":{"videoId":"abc","offlineSlate":{"liveStreamOfflineSlateRenderer":{"scheduledStartTime":"1600000000"}}}}}`, ytdl.ErrLiveNotStarted},
+	}
+
+	for _, c := range cases {
+		ps := new(ytdl.PlayabilityStatus)
+		if err := json.Unmarshal([]byte(c.json), ps); err != nil {
+			t.Fatal(err)
+		}
+
+		err := ps.Err()
+		if !errors.Is(err, c.want) || (c.want == nil) != (err == nil) {
+			t.Errorf("%s: got %v, want %v", c.json, err, c.want)
+			continue
+		}
+
+		var pe *ytdl.PlayabilityError
+		if err != nil && !errors.As(err, &pe) {
+			t.Errorf("%s: %v is not *PlayabilityError", c.json, err)
+		}
+		if c.want == ytdl.ErrLiveNotStarted && pe.ScheduledStart.Unix() != 1600000000 {
+			t.Errorf("ScheduledStart = %v", pe.ScheduledStart)
+		}
+	}
+}
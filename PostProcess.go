@@ -0,0 +1,193 @@
+package ytdl
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sam1677/ytdl/internal/ffmpeg"
+	"github.com/sam1677/ytdl/internal/logger"
+	u "github.com/sam1677/ytdl/internal/utils"
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//tmpProcessDir is subdirectory of temporary directory where PostProcessors write
+const tmpProcessDir = "process"
+
+//PostProcessor processes downloaded media (DownloadOptions.PostProcessors)
+//
+//Process reads PostProcessContext.Path and sets it to new file if it writes one
+type PostProcessor interface {
+	Process(pc *PostProcessContext) error
+}
+
+//PostProcessContext is state of a download passed through PostProcessors
+type PostProcessContext struct {
+	Client  *Client
+	Info    *VideoInfo
+	Video   *Format
+	Audio   *Format
+	Options *DownloadOptions
+
+	//Path is current media file in temporary directory
+	//
+	//It's moved to DownloadOptions.Path after the last PostProcessor.
+	//Extension of the final file follows Path
+	Path string
+	//AudioPath is downloaded Audio until Merge merges it into Path
+	AudioPath string
+	//HasVideo reports whether Path has video stream
+	HasVideo bool
+	//Start is start time of media in seconds which is not 0 for clips cut at segments
+	Start float64
+
+	tmpDir string
+	files  int
+	//vcodec and acodec are codecs of video and audio in Path or AudioPath ("" if unknown)
+	vcodec, acodec string
+}
+
+//TempPath returns new file path with ext in temporary directory of the download
+func (pc *PostProcessContext) TempPath(ext string) (string, error) {
+	dir := u.MergePathAndFilename(pc.tmpDir, tmpProcessDir)
+	err := (&u.Downloader{}).CreatePath(dir)
+	if err != nil {
+		return "", e.DbgErr(err)
+	}
+	pc.files++
+	return u.MergePathAndFilename(dir, strconv.Itoa(pc.files)+ext), nil
+}
+
+//FFMpeg runs ffmpeg of Client with args
+func (pc *PostProcessContext) FFMpeg(args ...string) error {
+	ff, err := pc.Client.ffmpeg()
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	_, err = ff.ExecWithDefaultHandle(append([]string{ff.Executable, "-hide_banner", "-nostdin", "-y"}, args...)...)
+	return e.DbgErr(err)
+}
+
+//run runs ffmpeg command of Client
+func (pc *PostProcessContext) run(cmd *ffmpeg.Command) error {
+	ff, err := pc.Client.ffmpeg()
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	return e.DbgErr(ff.Run(cmd))
+}
+
+//pipeline returns PostProcessors of options
+//
+//Merge is added first if AudioOverride is set and PostProcessors don't have it,
+//clips are trimmed, remuxed into Container (or container fitting merged codecs)
+//and get Subtitles before PostProcessors
+func (options *DownloadOptions) pipeline() []PostProcessor {
+	pps := []PostProcessor{}
+	hasMerge := false
+	for _, pp := range options.PostProcessors {
+		switch pp.(type) {
+		case Merge, *Merge:
+			hasMerge = true
+		}
+	}
+	if options.AudioOverride != nil && !hasMerge {
+		pps = append(pps, Merge{})
+	}
+	if options.isClip() {
+		pps = append(pps, trimClip{})
+	}
+	if options.Container != "" || options.AudioOverride != nil {
+		pps = append(pps, Remux{Container: options.Container})
+	}
+	if len(options.Subtitles) > 0 {
+		pps = append(pps, EmbedSubtitles{Languages: options.Subtitles, Auto: options.AutoSubtitles})
+	}
+	return append(pps, options.PostProcessors...)
+}
+
+//downloadAndProcess downloads Format and AudioOverride into temporary directory,
+//runs PostProcessors and moves result into options.Path
+func (f *Format) downloadAndProcess(options *DownloadOptions, limit *u.TokenBucket) error {
+	c := f.client()
+	tmpDir, err := c.makeTempDir()
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	defer func() {
+		logger.Debug(c.Logger, "removing temporary files", "path", tmpDir)
+		os.RemoveAll(tmpDir)
+	}()
+
+	pc := &PostProcessContext{
+		Client:   c,
+		Info:     f.Parent,
+		Video:    f,
+		Audio:    options.AudioOverride,
+		Options:  options,
+		HasVideo: f.hasVideo(),
+		tmpDir:   tmpDir,
+		vcodec:   f.videoCodec(),
+		acodec:   f.audioCodec(),
+	}
+	if options.AudioOverride != nil {
+		pc.acodec = options.AudioOverride.audioCodec()
+	}
+
+	// video is named after Format since options.Filename may have extension of other container
+	file, start, err := f.fetch(u.MergePathAndFilename(tmpDir, tmpVideoDir), "video"+f.ext(), options, limit)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	file.Close()
+	pc.Path, pc.Start = file.Name(), start
+
+	if audio := options.AudioOverride; audio != nil {
+		file, start, err := audio.fetch(u.MergePathAndFilename(tmpDir, tmpAudioDir), audio.Filename, options, limit)
+		if err != nil {
+			return e.DbgErr(e.Wrap(err, "Download audio", "", audio.Itag))
+		}
+		file.Close()
+		pc.AudioPath, pc.Start = file.Name(), math.Min(pc.Start, start)
+	}
+
+	for _, pp := range options.pipeline() {
+		name := strings.TrimPrefix(fmt.Sprintf("%T", pp), "*")
+		logger.Info(c.Logger, "post processing", "processor", name, "path", pc.Path)
+		err = pp.Process(pc)
+		if err != nil {
+			return e.DbgErr(e.Wrap(err, name, "", 0))
+		}
+	}
+
+	filename := options.Filename
+	if ext := filepath.Ext(pc.Path); ext != filepath.Ext(filename) {
+		var ok bool
+		filename, ok = resolveCollision(options.Path, strings.TrimSuffix(filename, filepath.Ext(filename))+ext, options.OnCollision)
+		if !ok {
+			logger.Info(c.Logger, "file exists, skipping", "path", options.Path, "file", filename)
+			return errSkipped
+		}
+	}
+
+	err = (&u.Downloader{Logger: c.Logger}).CreatePath(options.Path)
+	if err == nil {
+		err = moveFile(pc.Path, u.MergePathAndFilename(options.Path, filename))
+	}
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	options.Filename = filename
+	return f.finish(options)
+}
+
+//hasVideo reports whether Format has video stream
+func (f *Format) hasVideo() bool {
+	if f.ItagProp.ContentType != 0 {
+		return f.ItagProp.ContentType != Audio
+	}
+	return strings.HasPrefix(f.MimeType, "video/")
+}
@@ -0,0 +1,299 @@
+package ytdl_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sam1677/ytdl"
+)
+
+//upperCase writes content of Path in upper case into .txt file
+type upperCase struct {
+	seen *ytdl.PostProcessContext
+}
+
+func (uc *upperCase) Process(pc *ytdl.PostProcessContext) error {
+	uc.seen = pc
+	data, err := ioutil.ReadFile(pc.Path)
+	if err != nil {
+		return err
+	}
+	out, err := pc.TempPath(".txt")
+	if err != nil {
+		return err
+	}
+	pc.Path = out
+	return ioutil.WriteFile(out, bytes.ToUpper(data), 0644)
+}
+
+func TestPostProcessors(t *testing.T) {
+	ts := newMediaServer()
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+	c.TempDir = filepath.Join(dir, "tmp")
+
+	vi, err := c.GetVideoInfo("9bZkp7q19f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uc := &upperCase{}
+	err = vi.StreamingData.AdaptiveFormats.Videos().Best().Download(&ytdl.DownloadOptions{
+		Filename:       "video.mp4",
+		PostProcessors: []ytdl.PostProcessor{uc},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uc.seen == nil || !uc.seen.HasVideo || uc.seen.Info != vi {
+		t.Fatalf("unexpected context %+v", uc.seen)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "Downloads", "video.txt"))
+	if err != nil || string(data) != "137-MEDIA" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Downloads", "video.mp4")); !os.IsNotExist(err) {
+		t.Error("file with original extension exists")
+	}
+	if files, _ := ioutil.ReadDir(c.TempDir); len(files) != 0 {
+		t.Errorf("temporary files are left: %v", files)
+	}
+}
+
+//fakeFFMpeg logs arguments to $FFMPEG_LOG and copies first input to output
+const fakeFFMpeg = `#!/bin/sh
+case "$1" in
+-version) echo "ffmpeg version 6.1"; exit 0 ;;
+-encoders) echo " ------"; exit 0 ;;
+-muxers) echo " --"; echo "  E mp4,ipod,mov,matroska,webm,mp3,opus,ogg,flac,wav,image2 formats"; exit 0 ;;
+esac
+echo "$@" >> "$FFMPEG_LOG"
+in=""
+prev=""
+for a; do
+	if [ "$prev" = "-i" ] && [ -z "$in" ]; then in="$a"; fi
+	case "$a" in *.txt) cat "$a" >> "$FFMPEG_LOG" ;; esac
+	prev="$a"
+	last="$a"
+done
+cp "$in" "$last"
+`
+
+func TestBuiltinPostProcessors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	ts := newMediaServer()
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+
+	c.FFMpegPath = filepath.Join(dir, "ffmpeg")
+	err := ioutil.WriteFile(c.FFMpegPath, []byte(fakeFFMpeg), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "ffmpeg.log")
+	os.Setenv("FFMPEG_LOG", log)
+	defer os.Unsetenv("FFMPEG_LOG")
+
+	vi, err := c.GetVideoInfo("9bZkp7q19f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vi.StreamingData.AdaptiveFormats.Videos().Best().Download(&ytdl.DownloadOptions{
+		Filename:       "video.mp4",
+		PostProcessors: []ytdl.PostProcessor{ytdl.EmbedMetadata{}, ytdl.Remux{Container: "mkv"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "Downloads", "video.mkv"))
+	if err != nil || string(data) != "137-media" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	logged, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"-f ffmetadata -i",
+		"-map 0 -c copy -map_metadata 1 -map_chapters 1",
+		"title=PSY - GANGNAM STYLE\n",
+		"2.mp4 -map 0 -c copy ",
+		"3.mkv\n",
+	} {
+		if !strings.Contains(string(logged), want) {
+			t.Errorf("ffmpeg log doesn't contain %q:\n%s", want, logged)
+		}
+	}
+}
+
+const mixedPlayerResponse = `{
+	"playabilityStatus": {"status": "OK"},
+	"streamingData": {
+		"expiresInSeconds": "21540",
+		"adaptiveFormats": [
+			{"itag": 137, "url": "MEDIA/videoplayback?itag=137", "mimeType": "video/mp4; codecs=\"avc1.640028\"", "qualityLabel": "1080p", "quality": "hd1080"},
+			{"itag": 251, "url": "MEDIA/videoplayback?itag=251", "mimeType": "audio/webm; codecs=\"opus\"", "quality": "tiny"}
+		]
+	},
+	"videoDetails": {"videoId": "mixedCodecs", "title": "mixed"}
+}`
+
+func TestContainer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	ts := newMediaServer()
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+	err := ioutil.WriteFile(filepath.Join(dir, "jsonCache", "mixedCodecs.json"),
+		[]byte(strings.Replace(mixedPlayerResponse, "MEDIA", ts.URL, -1)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.FFMpegPath = filepath.Join(dir, "ffmpeg")
+	ffmpegScript := strings.Replace(fakeFFMpeg, `echo " ------"`, `echo " ------"; echo " V..... libvpx-vp9 VP9"`, 1)
+	err = ioutil.WriteFile(c.FFMpegPath, []byte(ffmpegScript), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "ffmpeg.log")
+	os.Setenv("FFMPEG_LOG", log)
+	defer os.Unsetenv("FFMPEG_LOG")
+
+	vi, err := c.GetVideoInfo("mixedCodecs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video := vi.StreamingData.AdaptiveFormats.Videos().Best()
+	audio := vi.StreamingData.AdaptiveFormats.Audios().Best()
+
+	for _, tc := range []struct {
+		container, file, args string
+	}{
+		{"", "video.mkv", "-c:v copy -c:a copy"},
+		{"webm", "video.webm", "-c:v libvpx-vp9 -c:a copy"},
+	} {
+		os.Remove(log)
+		err = video.Download(&ytdl.DownloadOptions{Filename: "video.mp4", AudioOverride: audio, Container: tc.container})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "Downloads", tc.file))
+		if err != nil || string(data) != "137-media" {
+			t.Errorf("%q: got %q, %v", tc.container, data, err)
+		}
+		logged, _ := ioutil.ReadFile(log)
+		if lines := strings.Split(strings.TrimSpace(string(logged)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], tc.args) {
+			t.Errorf("%q: ffmpeg is run with\n%s", tc.container, logged)
+		}
+	}
+
+	//video.mkv exists, so it's skipped before downloading
+	os.Remove(log)
+	err = video.Download(&ytdl.DownloadOptions{Filename: "video.mp4", AudioOverride: audio, OnCollision: ytdl.Skip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(log); !os.IsNotExist(err) {
+		t.Errorf("ffmpeg is run for skipped download: %v", err)
+	}
+}
+
+//failing is PostProcessor which always fails
+type failing struct{}
+
+func (failing) Process(pc *ytdl.PostProcessContext) error {
+	return errors.New("failed")
+}
+
+func TestPostProcessorFailure(t *testing.T) {
+	ts := newMediaServer()
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+	c.TempDir = filepath.Join(dir, "tmp")
+
+	vi, err := c.GetVideoInfo("9bZkp7q19f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vi.StreamingData.AdaptiveFormats.Videos().Best().Download(&ytdl.DownloadOptions{
+		AudioOverride:  vi.StreamingData.AdaptiveFormats.Audios().Best(),
+		PostProcessors: []ytdl.PostProcessor{ytdl.Merge{}, failing{}},
+	})
+	if err == nil {
+		t.Fatal("error of PostProcessor is not returned")
+	}
+	if files, _ := ioutil.ReadDir(c.TempDir); len(files) != 0 {
+		t.Errorf("temporary files are left: %v", files)
+	}
+}
+
+func TestEmbedThumbnail(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	ts := newMediaServer()
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+	cache := filepath.Join(dir, "jsonCache", "9bZkp7q19f0.json")
+	data, err := ioutil.ReadFile(cache)
+	if err == nil {
+		thumbnail := `"thumbnail": {"thumbnails": [{"url": "` + ts.URL + `/vi_webp/maxresdefault.webp", "width": 1280, "height": 720}]}, "videoId"`
+		data = []byte(strings.Replace(string(data), `"videoId"`, thumbnail, 1))
+		err = ioutil.WriteFile(cache, data, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.FFMpegPath = filepath.Join(dir, "ffmpeg")
+	ffmpegScript := strings.Replace(fakeFFMpeg, `echo " ------"`, `echo " ------"; echo " V..... mjpeg MJPEG"`, 1)
+	err = ioutil.WriteFile(c.FFMpegPath, []byte(ffmpegScript), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "ffmpeg.log")
+	os.Setenv("FFMPEG_LOG", log)
+	defer os.Unsetenv("FFMPEG_LOG")
+
+	vi, err := c.GetVideoInfo("9bZkp7q19f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vi.StreamingData.AdaptiveFormats.Videos().Best().Download(&ytdl.DownloadOptions{
+		Filename:       "video.mp4",
+		PostProcessors: []ytdl.PostProcessor{ytdl.EmbedThumbnail{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logged, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1.webp -map 0 -map 1 -c copy -c:v:1 mjpeg -disposition:v:1 attached_pic "; !strings.Contains(string(logged), want) {
+		t.Errorf("ffmpeg log doesn't contain %q:\n%s", want, logged)
+	}
+}
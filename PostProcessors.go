@@ -0,0 +1,665 @@
+package ytdl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sam1677/ytdl/internal/ffmpeg"
+	"github.com/sam1677/ytdl/internal/logger"
+	"github.com/sam1677/ytdl/internal/mkv"
+	fmp4 "github.com/sam1677/ytdl/internal/mp4"
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//Merge merges downloaded AudioOverride into video
+//
+//Fragmented mp4 video and m4a audio, and webm video and audio are merged without ffmpeg.
+//It's added to PostProcessors automatically if AudioOverride is set
+type Merge struct{}
+
+//Process implements PostProcessor
+func (Merge) Process(pc *PostProcessContext) error {
+	if pc.AudioPath == "" {
+		return nil
+	}
+	video, audio := pc.Video, pc.Audio
+	out, err := pc.TempPath(filepath.Ext(pc.Path))
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	switch {
+	case video.isMP4() && audio.isMP4():
+		err = mergeFiles(pc.Path, pc.AudioPath, out, func(w *os.File, v, a io.Reader) error {
+			bw := bufio.NewWriter(w)
+			if err := fmp4.Merge(bw, v, a); err != nil {
+				return err
+			}
+			return bw.Flush()
+		})
+		if !errors.Is(err, fmp4.ErrNotFragmented) {
+			if err == nil {
+				err = pc.validateNative(out)
+			}
+			return pc.merged(out, e.Wrap(err, "MergeMP4", "", audio.Itag))
+		}
+		logger.Info(pc.Client.Logger, "mp4 is not fragmented, merging with ffmpeg", "video", pc.Path)
+
+	case video.isWebM() && audio.isWebM():
+		err = mergeFiles(pc.Path, pc.AudioPath, out, func(w *os.File, v, a io.Reader) error {
+			return mkv.Merge(w, v, a)
+		})
+		if !errors.Is(err, mkv.ErrUnsupported) {
+			if err == nil {
+				err = pc.validateNative(out)
+			}
+			return pc.merged(out, e.Wrap(err, "MergeWebM", "", audio.Itag))
+		}
+		logger.Info(pc.Client.Logger, "webm is not supported, merging with ffmpeg", "video", pc.Path)
+	}
+
+	ff, err := pc.Client.ffmpeg()
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	ct, vcodec, acodec, err := pc.target(pc.Options.Container)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	if ct.ext != filepath.Ext(out) {
+		out, err = pc.TempPath(ct.ext)
+		if err != nil {
+			return e.DbgErr(err)
+		}
+	}
+
+	cmd := &ffmpeg.Command{Overwrite: true}
+	v := cmd.Input(pc.Path)
+	a := cmd.Input(pc.AudioPath)
+	cmd.Output(out).Map(v, "v").Map(a, "a").Codec("v", encoder(vcodec)).Codec("a", encoder(acodec))
+	err = ff.Run(cmd)
+	if err != nil {
+		return e.DbgErr(e.Wrap(err, "MergeVideoNAudio", "", audio.Itag))
+	}
+
+	err = video.validateMerged(ff, out, !pc.Options.isClip())
+	if err == nil {
+		pc.transcoded(vcodec, acodec)
+	}
+	return pc.merged(out, err)
+}
+
+//merged sets Path to merged file if err is nil
+func (pc *PostProcessContext) merged(out string, err error) error {
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	pc.Path, pc.AudioPath = out, ""
+	return nil
+}
+
+//validateNative validates file merged without ffmpeg if ffmpeg is found
+func (pc *PostProcessContext) validateNative(out string) error {
+	ff, err := pc.Client.ffmpeg()
+	if err != nil {
+		logger.Debug(pc.Client.Logger, "ffmpeg is not found, merged file is not validated", "path", out, "err", err)
+		return nil
+	}
+	return e.DbgErr(pc.Video.validateMerged(ff, out, !pc.Options.isClip()))
+}
+
+//mergeFiles merges video and audio into out with merge instead of ffmpeg
+//
+//out is removed if merge fails
+func mergeFiles(video, audio, out string, merge func(w *os.File, video, audio io.Reader) error) error {
+	vf, err := os.Open(video)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	defer vf.Close()
+	af, err := os.Open(audio)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	defer af.Close()
+
+	of, err := os.Create(out)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	err = merge(of, bufio.NewReader(vf), bufio.NewReader(af))
+	if cerr := of.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+	}
+	return e.DbgErr(err)
+}
+
+//validateMerged checks merged file with ffprobe
+//
+//Duration is checked if checkDuration is set. Validation is skipped if ffprobe is not found
+func (f *Format) validateMerged(ff *ffmpeg.FFMpeg, path string, checkDuration bool) error {
+	var length float64
+	if f.Parent != nil && checkDuration {
+		length, _ = strconv.ParseFloat(f.Parent.VideoDetails.LengthSeconds, 64)
+	}
+
+	err := ff.ValidateMerged(path, length)
+	if errors.Is(err, e.ErrFFProbeNotFound) {
+		logger.Warn(f.client().Logger, "ffprobe is not found, merged file is not validated", "path", path)
+		return nil
+	}
+	return e.DbgErr(e.Wrap(err, "ValidateMerged", "", 0))
+}
+
+//isMP4 reports whether Format is mp4 (or m4a) container
+func (f *Format) isMP4() bool {
+	typ, _, err := mime.ParseMediaType(f.MimeType)
+	return err == nil && (typ == "video/mp4" || typ == "audio/mp4")
+}
+
+//isWebM reports whether Format is webm container
+func (f *Format) isWebM() bool {
+	typ, _, err := mime.ParseMediaType(f.MimeType)
+	return err == nil && (typ == "video/webm" || typ == "audio/webm")
+}
+
+//codecs returns codecs parameter of MimeType (e.g. ["avc1.640028"], ["opus"])
+func (f *Format) codecs() []string {
+	_, params, err := mime.ParseMediaType(f.MimeType)
+	if err != nil || params["codecs"] == "" {
+		return nil
+	}
+	codecs := strings.Split(params["codecs"], ",")
+	for i := range codecs {
+		codecs[i] = strings.TrimSpace(codecs[i])
+	}
+	return codecs
+}
+
+//videoCodec returns ffmpeg name of video codec of Format
+func (f *Format) videoCodec() string {
+	for _, c := range f.codecs() {
+		switch {
+		case strings.HasPrefix(c, "avc1"):
+			return "h264"
+		case strings.HasPrefix(c, "hev1"), strings.HasPrefix(c, "hvc1"):
+			return "hevc"
+		case strings.HasPrefix(c, "av01"):
+			return "av1"
+		case c == "vp9", strings.HasPrefix(c, "vp09"):
+			return "vp9"
+		case c == "vp8":
+			return "vp8"
+		case strings.HasPrefix(c, "mp4v"):
+			return "mpeg4"
+		}
+	}
+	return ""
+}
+
+//audioCodec returns ffmpeg name of audio codec of Format
+func (f *Format) audioCodec() string {
+	for _, c := range f.codecs() {
+		switch {
+		case strings.HasPrefix(c, "mp4a"):
+			return "aac"
+		case c == "opus", c == "vorbis", c == "mp3", c == "flac":
+			return c
+		}
+	}
+	return ""
+}
+
+//audioFormats are formats of ExtractAudio: extension, encoder and codec copied as it is
+var audioFormats = map[string]struct {
+	ext, encoder, codec string
+}{
+	"m4a":  {".m4a", "aac", "aac"},
+	"mp3":  {".mp3", "libmp3lame", "mp3"},
+	"opus": {".opus", "libopus", "opus"},
+	"ogg":  {".ogg", "libvorbis", "vorbis"},
+	"flac": {".flac", "flac", "flac"},
+	"wav":  {".wav", "pcm_s16le", ""},
+}
+
+//ExtractAudio extracts audio stream into audio file
+type ExtractAudio struct {
+	//Format is one of "m4a", "mp3", "opus", "ogg", "flac" and "wav"
+	//
+	//Empty Format keeps codec of downloaded audio (m4a for aac, opus and ogg for vorbis)
+	Format string
+	//Bitrate is bitrate of encoded audio (e.g. "192k"), empty means encoder's default
+	Bitrate string
+}
+
+//Process implements PostProcessor
+func (ea ExtractAudio) Process(pc *PostProcessContext) error {
+	src, codec := pc.Path, pc.acodec
+	if pc.AudioPath != "" {
+		src = pc.AudioPath
+	}
+
+	format := ea.Format
+	if format == "" {
+		format = "mp3"
+		for name, af := range audioFormats {
+			if af.codec != "" && af.codec == codec {
+				format = name
+			}
+		}
+	}
+	af, ok := audioFormats[format]
+	if !ok {
+		return e.DbgErr(fmt.Errorf("%w: unknown audio format %q", e.ErrYtdl, format))
+	}
+
+	out, err := pc.TempPath(af.ext)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	cmd := &ffmpeg.Command{Overwrite: true}
+	o := cmd.Output(out).Map(cmd.Input(src), "a:0")
+	if af.codec != "" && af.codec == codec {
+		o.Codec("a", "copy")
+	} else {
+		o.Codec("a", af.encoder)
+		if ea.Bitrate != "" {
+			o.Set("-b:a", ea.Bitrate)
+		}
+	}
+
+	err = pc.run(cmd)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	pc.Path, pc.AudioPath, pc.HasVideo = out, "", false
+	pc.vcodec, pc.acodec = "", af.codec
+	return nil
+}
+
+//Remux changes container copying streams which the container supports
+//and transcoding only the others (video into h264 for mp4 and mov, vp9 for webm,
+//audio into aac for mp4 and mov, opus for webm)
+//
+//It's added to PostProcessors automatically if DownloadOptions.Container or AudioOverride is set
+type Remux struct {
+	//Container is "mp4", "mkv", "webm" or "mov"
+	//
+	//Empty Container keeps container of media if it supports the codecs, otherwise mkv is used
+	Container string
+}
+
+//Process implements PostProcessor
+func (r Remux) Process(pc *PostProcessContext) error {
+	ct, vcodec, acodec, err := pc.target(r.Container)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	if ct.ext == filepath.Ext(pc.Path) && vcodec == "" && acodec == "" {
+		return nil
+	}
+	out, err := pc.TempPath(ct.ext)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	cmd := &ffmpeg.Command{Overwrite: true}
+	o := cmd.Output(out).Map(cmd.Input(pc.Path), "").Codec("", "copy")
+	if vcodec != "" {
+		o.Codec("v", encoder(vcodec))
+	}
+	if acodec != "" {
+		o.Codec("a", encoder(acodec))
+	}
+	if ct.ext != ".mkv" {
+		o.Codec("s", subtitleCodec(ct.ext))
+	}
+	err = pc.run(cmd)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	pc.Path = out
+	pc.transcoded(vcodec, acodec)
+	return nil
+}
+
+//Loudnorm is options of NormalizeAudio
+type Loudnorm = ffmpeg.Loudnorm
+
+//NormalizeAudio normalizes loudness of audio to EBU R128 targets with two pass loudnorm of ffmpeg
+//
+//Audio is reencoded with encoder of its codec and video is copied
+type NormalizeAudio struct {
+	Loudnorm
+}
+
+//Process implements PostProcessor
+func (na NormalizeAudio) Process(pc *PostProcessContext) error {
+	src := &pc.Path
+	if pc.AudioPath != "" {
+		src = &pc.AudioPath
+	}
+	ext := filepath.Ext(*src)
+	out, err := pc.TempPath(ext)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	ff, err := pc.Client.ffmpeg()
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	err = ff.NormalizeLoudness(*src, out, na.Loudnorm, audioEncoder(pc.acodec, ext))
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	*src = out
+	return nil
+}
+
+//audioEncoder returns encoder of audio codec, or default encoder of container ext if codec is unknown
+func audioEncoder(codec, ext string) string {
+	for _, af := range audioFormats {
+		if af.codec != "" && af.codec == codec {
+			return af.encoder
+		}
+	}
+	if ct, ok := containers[strings.TrimPrefix(ext, ".")]; ok && ct.audio != nil {
+		return encoders[ct.audio[0]]
+	}
+	for _, af := range audioFormats {
+		if af.ext == ext {
+			return af.encoder
+		}
+	}
+	return "aac"
+}
+
+//EmbedMetadata writes title, artist, date, description and chapters into media
+type EmbedMetadata struct{}
+
+//Process implements PostProcessor
+func (EmbedMetadata) Process(pc *PostProcessContext) error {
+	if pc.Info == nil {
+		return nil
+	}
+	info := pc.Info.InfoJSON()
+
+	var sb strings.Builder
+	sb.WriteString(";FFMETADATA1\n")
+	for _, kv := range [][2]string{
+		{"title", info.Title},
+		{"artist", info.Channel.Name},
+		{"date", info.UploadDate},
+		{"genre", info.Category},
+		{"description", info.Description},
+		{"comment", "https://www.youtube.com/watch?v=" + info.VideoID},
+	} {
+		if kv[1] != "" {
+			fmt.Fprintf(&sb, "%s=%s\n", kv[0], escapeMetadata(kv[1]))
+		}
+	}
+	//chapters don't fit clips
+	if !pc.Options.isClip() {
+		for _, ch := range info.Chapters {
+			fmt.Fprintf(&sb, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+				int64(ch.StartSeconds*1000), int64(ch.EndSeconds*1000), escapeMetadata(ch.Title))
+		}
+	}
+
+	meta, err := pc.TempPath(".txt")
+	if err == nil {
+		err = ioutil.WriteFile(meta, []byte(sb.String()), 0644)
+	}
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	out, err := pc.TempPath(filepath.Ext(pc.Path))
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	cmd := &ffmpeg.Command{Overwrite: true}
+	in := cmd.Input(pc.Path)
+	cmd.Input(meta, "-f", "ffmetadata")
+	cmd.Output(out).Map(in, "").Codec("", "copy").Set("-map_metadata", "1", "-map_chapters", "1")
+	err = pc.run(cmd)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	pc.Path = out
+	return nil
+}
+
+//escapeMetadata escapes special characters of ffmetadata
+func escapeMetadata(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "=", "\\=", ";", "\\;", "#", "\\#", "\n", "\\\n").Replace(s)
+}
+
+//EmbedThumbnail embeds the largest thumbnail of video as cover art
+//
+//It's supported for mp4, m4a, mov, mkv and mp3 and skipped for others
+type EmbedThumbnail struct{}
+
+//Process implements PostProcessor
+func (EmbedThumbnail) Process(pc *PostProcessContext) error {
+	ext := filepath.Ext(pc.Path)
+	switch ext {
+	case ".mp4", ".m4a", ".mov", ".mkv", ".mka", ".mp3":
+	default:
+		logger.Warn(pc.Client.Logger, "thumbnail can't be embedded", "container", ext)
+		return nil
+	}
+	if pc.Info == nil || len(pc.Info.VideoDetails.Thumbnail.Thumbnails) == 0 {
+		logger.Warn(pc.Client.Logger, "video has no thumbnail")
+		return nil
+	}
+
+	thumbs := pc.Info.VideoDetails.Thumbnail.Thumbnails
+	best := thumbs[0]
+	for _, th := range thumbs {
+		if th.Width*th.Height > best.Width*best.Height {
+			best = th
+		}
+	}
+	thumbExt := ".jpg"
+	if u, err := url.Parse(best.URL); err == nil && path.Ext(u.Path) != "" {
+		thumbExt = path.Ext(u.Path)
+	}
+
+	data, err := pc.Client.downloader(pc.Info.videoID()).Get(best.URL)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	thumb, err := pc.TempPath(thumbExt)
+	if err == nil {
+		err = ioutil.WriteFile(thumb, data, 0644)
+	}
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	out, err := pc.TempPath(ext)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	cmd := &ffmpeg.Command{Overwrite: true}
+	in := cmd.Input(pc.Path)
+	o := cmd.Output(out).Map(in, "").Codec("", "copy")
+	switch ext {
+	case ".mkv", ".mka":
+		mimeType := mime.TypeByExtension(thumbExt)
+		if mimeType == "" {
+			mimeType = "image/jpeg"
+		}
+		o.Set("-attach", thumb, "-metadata:s:t", "mimetype="+mimeType, "-metadata:s:t", "filename=cover"+thumbExt)
+	default:
+		//cover is the video stream after existing one
+		cover := "v:0"
+		if pc.HasVideo {
+			cover = "v:1"
+		}
+		o.Map(cmd.Input(thumb), "").Set("-disposition:"+cover, "attached_pic")
+		//mp4 and mp3 take only jpeg and png cover art, Youtube thumbnails are often webp
+		if thumbExt != ".jpg" && thumbExt != ".jpeg" && thumbExt != ".png" {
+			o.Codec(cover, "mjpeg")
+		}
+		if ext == ".mp3" {
+			o.Set("-id3v2_version", "3")
+		}
+	}
+
+	err = pc.run(cmd)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	pc.Path = out
+	return nil
+}
+
+//SubtitleFile is subtitle file embedded by EmbedSubtitles
+type SubtitleFile struct {
+	Path string
+	//Language is ISO 639 language code (e.g. "eng", "en")
+	Language string
+	//Title is name of subtitle stream (e.g. "English (auto-generated)")
+	Title string
+
+	//captions are timed on the whole video, so they're cut to clips
+	captions bool
+}
+
+//EmbedSubtitles embeds subtitle files and caption tracks of video as soft subtitle streams
+//
+//Caption tracks are cut to the clip if DownloadOptions.Start or End is set, Files are embedded as they are.
+//Subtitles are converted to mov_text for mp4 and mov, to WebVTT for webm and to SRT for mkv
+type EmbedSubtitles struct {
+	Files []SubtitleFile
+	//Languages selects caption tracks of video to download and embed (see VideoInfo.SelectCaptions)
+	Languages []string
+	//Auto selects auto generated caption tracks of languages which don't have manual one
+	Auto bool
+}
+
+//Process implements PostProcessor
+func (es EmbedSubtitles) Process(pc *PostProcessContext) error {
+	files := append([]SubtitleFile{}, es.Files...)
+	if len(es.Languages) > 0 && pc.Info != nil {
+		tracks := pc.Info.SelectCaptions(es.Languages, es.Auto)
+		if len(tracks) == 0 {
+			logger.Warn(pc.Client.Logger, "video has no caption tracks of languages", "languages", strings.Join(es.Languages, ","))
+		}
+		for _, track := range tracks {
+			file, err := pc.downloadCaptions(track)
+			if err != nil {
+				return e.DbgErr(err)
+			}
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	ext := filepath.Ext(pc.Path)
+	out, err := pc.TempPath(ext)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	existing, err := pc.subtitleStreams()
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	cmd := &ffmpeg.Command{Overwrite: true}
+	in := cmd.Input(pc.Path)
+	o := cmd.Output(out).Map(in, "").Codec("", "copy").Codec("s", subtitleCodec(ext))
+	for i, sub := range files {
+		var args []string
+		if sub.captions && pc.Options.isClip() {
+			args = []string{"-ss", seconds(pc.Start)}
+			if pc.Options.End > 0 {
+				args = append(args, "-t", seconds(pc.Options.End.Seconds()-pc.Start))
+			}
+		}
+		o.Map(cmd.Input(sub.Path, args...), "")
+
+		// subtitle streams of input come first
+		stream := fmt.Sprintf("-metadata:s:s:%d", existing+i)
+		if sub.Language != "" {
+			o.Set(stream, "language="+languageTag(sub.Language))
+		}
+		if sub.Title != "" {
+			o.Set(stream, "title="+sub.Title)
+		}
+	}
+
+	err = pc.run(cmd)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	pc.Path = out
+	return nil
+}
+
+//subtitleStreams returns number of subtitle streams in Path
+//
+//It returns 0 if ffprobe is not found
+func (pc *PostProcessContext) subtitleStreams() (int, error) {
+	ff, err := pc.Client.ffmpeg()
+	if err != nil {
+		return 0, e.DbgErr(err)
+	}
+	info, err := ff.ProbeMedia(pc.Path)
+	if errors.Is(err, e.ErrFFProbeNotFound) {
+		logger.Debug(pc.Client.Logger, "ffprobe is not found, assuming media has no subtitles", "path", pc.Path)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, e.DbgErr(err)
+	}
+	return len(info.StreamsOf(ffmpeg.CodecTypeSubtitle)), nil
+}
+
+//downloadCaptions downloads caption track as WebVTT into temporary directory
+func (pc *PostProcessContext) downloadCaptions(track CaptionTrack) (SubtitleFile, error) {
+	data, err := pc.Client.downloader(pc.Info.videoID()).Get(track.URL("vtt"))
+	if err != nil {
+		return SubtitleFile{}, e.DbgErr(err)
+	}
+	path, err := pc.TempPath(".vtt")
+	if err == nil {
+		err = ioutil.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		return SubtitleFile{}, e.DbgErr(err)
+	}
+	logger.Debug(pc.Client.Logger, "caption track downloaded", "language", track.LanguageCode, "auto", track.IsAuto())
+	return SubtitleFile{Path: path, Language: track.LanguageCode, Title: track.Name.String(), captions: true}, nil
+}
+
+//subtitleCodec returns subtitle codec supported by container of ext
+func subtitleCodec(ext string) string {
+	switch ext {
+	case ".mp4", ".m4a", ".mov":
+		return "mov_text"
+	case ".webm":
+		return "webvtt"
+	}
+	return "srt"
+}
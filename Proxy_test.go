@@ -0,0 +1,102 @@
+package ytdl_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sam1677/ytdl"
+)
+
+func TestProxy(t *testing.T) {
+	tunnel := ""
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			tunnel = r.Host
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+
+	c := &ytdl.Client{
+		Proxy:        proxy.URL,
+		DisableCache: true,
+		Retry:        &ytdl.RetryPolicy{MaxAttempts: 1},
+	}
+	_, err := c.GetVideoInfo("9bZkp7q19f0")
+	if err == nil || tunnel != "www.youtube.com:443" {
+		t.Errorf("request is not sent through proxy (tunnel: %q, err: %v)", tunnel, err)
+	}
+}
+
+func TestProxyPool(t *testing.T) {
+	pool, err := ytdl.NewProxyPool("http://10.0.0.1:3128", "socks5://10.0.0.2:1080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.MaxFailures = 2
+
+	a, _ := pool.Assign("video-a")
+	b, _ := pool.Assign("video-b")
+	if a.String() == b.String() {
+		t.Errorf("videos are not assigned in rotation: %v, %v", a, b)
+	}
+	if again, _ := pool.Assign("video-a"); again.String() != a.String() {
+		t.Errorf("video-a is moved to %v", again)
+	}
+
+	pool.ReportFailure(a)
+	pool.ReportFailure(a)
+	if moved, _ := pool.Assign("video-a"); moved.String() != b.String() {
+		t.Errorf("video-a is not moved from bad proxy (got %v)", moved)
+	}
+
+	pool.ReportFailure(b)
+	pool.ReportFailure(b)
+	if _, err := pool.Assign("video-c"); !errors.Is(err, ytdl.ErrNoProxyAvailable) {
+		t.Errorf("got %v, want ErrNoProxyAvailable", err)
+	}
+	if len(pool.Bad()) != 2 {
+		t.Errorf("Bad() = %v", pool.Bad())
+	}
+
+	if _, err := ytdl.NewProxyPool("ftp://10.0.0.3"); err == nil {
+		t.Error("unsupported scheme is accepted")
+	}
+}
+
+func TestProxyPoolRetry(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	tunnel := ""
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			tunnel = r.Host
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer alive.Close()
+
+	pool, err := ytdl.NewProxyPool(dead.URL, alive.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.MaxFailures = 1
+
+	c := &ytdl.Client{
+		ProxyPool:    pool,
+		DisableCache: true,
+		Retry:        &ytdl.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, Multiplier: 1},
+	}
+	_, err = c.GetVideoInfo("9bZkp7q19f0")
+	if err == nil || tunnel != "www.youtube.com:443" {
+		t.Errorf("retry is not sent through next proxy (tunnel: %q, err: %v)", tunnel, err)
+	}
+	if bad := pool.Bad(); len(bad) == 0 || bad[0].Host != strings.TrimPrefix(dead.URL, "http://") {
+		t.Errorf("Bad() = %v", bad)
+	}
+}
@@ -0,0 +1,163 @@
+package ytdl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//defaultMaxFilenameBytes is used if SanitizeOptions.MaxBytes is 0 (limit of most file systems)
+const defaultMaxFilenameBytes = 255
+
+//SanitizeOptions describes how filenames made from metadata are sanitized
+//
+//Path separators, control characters and leading or trailing spaces are always removed
+type SanitizeOptions struct {
+	//RestrictASCII replaces non ASCII characters (e.g. emoji) with "_"
+	RestrictASCII bool
+	//WindowsSafe replaces characters Windows doesn't allow (<>:"/\|?*),
+	//trailing dots and reserved names (CON, NUL, COM1, ...)
+	WindowsSafe bool
+	//MaxBytes limits length of each path element in bytes, extension is kept (default: 255)
+	MaxBytes int
+}
+
+//CollisionPolicy describes what Download does if output file already exists
+type CollisionPolicy int
+
+const (
+	//Overwrite overwrites existing file
+	Overwrite CollisionPolicy = iota
+	//Skip doesn't download anything
+	Skip
+	//NumberSuffix appends " (1)", " (2)", ... to filename
+	NumberSuffix
+)
+
+func (p CollisionPolicy) String() string {
+	switch p {
+	case Overwrite:
+		return "overwrite"
+	case Skip:
+		return "skip"
+	case NumberSuffix:
+		return "number suffix"
+	}
+	return ""
+}
+
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+//SanitizeFilename makes name usable as a single path element
+func SanitizeFilename(name string, opts SanitizeOptions) string {
+	var sb strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '/' || r == '\\' || r == 0 || r == utf8.RuneError:
+			r = '_'
+		case unicode.IsControl(r):
+			r = ' '
+		case opts.RestrictASCII && r > unicode.MaxASCII:
+			r = '_'
+		case opts.WindowsSafe && strings.ContainsRune(`<>:"|?*`, r):
+			r = '_'
+		}
+		sb.WriteRune(r)
+	}
+	name = sb.String()
+
+	if opts.RestrictASCII {
+		for strings.Contains(name, "__") {
+			name = strings.ReplaceAll(name, "__", "_")
+		}
+	}
+
+	name = strings.TrimSpace(name)
+	if opts.WindowsSafe {
+		name = strings.TrimRight(name, ". ")
+		stem := strings.SplitN(name, ".", 2)[0]
+		if windowsReserved[strings.ToUpper(strings.TrimSpace(stem))] {
+			name = "_" + name
+		}
+	}
+
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxFilenameBytes
+	}
+	name = truncateBytes(name, maxBytes)
+
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+//SanitizePath sanitizes each element of relative path
+func SanitizePath(path string, opts SanitizeOptions) string {
+	elems := strings.Split(filepath.ToSlash(path), "/")
+	for i, elem := range elems {
+		elems[i] = SanitizeFilename(elem, opts)
+	}
+	return filepath.Join(elems...)
+}
+
+//truncateBytes cuts name to maxBytes keeping extension and valid UTF-8
+func truncateBytes(name string, maxBytes int) string {
+	if len(name) <= maxBytes {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	if len(ext) >= maxBytes {
+		ext = ""
+	}
+	stem := name[:len(name)-len(ext)]
+
+	limit := maxBytes - len(ext)
+	cut := 0
+	for i := range stem {
+		if i > limit {
+			break
+		}
+		cut = i
+	}
+	if len(stem) <= limit {
+		cut = len(stem)
+	}
+	return strings.TrimSpace(stem[:cut]) + ext
+}
+
+//resolveCollision returns filename to write in path according to policy
+//and false if download must be skipped
+func resolveCollision(path, filename string, policy CollisionPolicy) (string, bool) {
+	if policy == Overwrite || !exists(filepath.Join(path, filename)) {
+		return filename, true
+	}
+	if policy == Skip {
+		return filename, false
+	}
+
+	ext := filepath.Ext(filename)
+	stem := strings.TrimSuffix(filename, ext)
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		if !exists(filepath.Join(path, name)) {
+			return name, true
+		}
+	}
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,69 @@
+package ytdl_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sam1677/ytdl"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	cases := []struct {
+		name string
+		opts ytdl.SanitizeOptions
+		want string
+	}{
+		{"AC/DC: Back in Black", ytdl.SanitizeOptions{}, "AC_DC: Back in Black"},
+		{"AC/DC: Back in Black?", ytdl.SanitizeOptions{WindowsSafe: true}, "AC_DC_ Back in Black_"},
+		{"con.mp4", ytdl.SanitizeOptions{WindowsSafe: true}, "_con.mp4"},
+		{"trailing dots...", ytdl.SanitizeOptions{WindowsSafe: true}, "trailing dots"},
+		{"강남스타일 🐴.mp4", ytdl.SanitizeOptions{RestrictASCII: true}, "_ _.mp4"},
+		{"line\nbreak", ytdl.SanitizeOptions{}, "line break"},
+		{"..", ytdl.SanitizeOptions{}, "_"},
+		{"강남스타일.mp4", ytdl.SanitizeOptions{MaxBytes: 11}, "강남.mp4"},
+	}
+	for _, c := range cases {
+		if got := ytdl.SanitizeFilename(c.name, c.opts); got != c.want {
+			t.Errorf("SanitizeFilename(%q, %+v) = %q, want %q", c.name, c.opts, got, c.want)
+		}
+	}
+}
+
+func TestDownloadCollision(t *testing.T) {
+	ts := newMediaServer()
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+
+	vi, err := c.GetVideoInfo("9bZkp7q19f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video := vi.StreamingData.AdaptiveFormats.Videos().First()
+
+	for _, policy := range []ytdl.CollisionPolicy{ytdl.NumberSuffix, ytdl.NumberSuffix, ytdl.NumberSuffix, ytdl.Skip, ytdl.Overwrite} {
+		err = video.Download(&ytdl.DownloadOptions{
+			FilenameTemplate: "{{.Title}}.{{.Ext}}",
+			OnCollision:      policy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "Downloads", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, f := range files {
+		got = append(got, filepath.Base(f))
+	}
+	want := "PSY - GANGNAM STYLE (1).mp4,PSY - GANGNAM STYLE (2).mp4,PSY - GANGNAM STYLE.mp4"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
@@ -4,15 +4,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"net/url"
 	"reflect"
 	"regexp"
 	"sort"
+	"strings"
+	"time"
 
-	u "github.com/sam1677/ytdl/internal/utils"
-	e "github.com/sam1677/ytdl/internal/ytdlerrors"
+	"github.com/sam1677/ytdl/internal/logger"
+	e "github.com/sam1677/ytdl/ytdlerrors"
 )
 
 //FormatList describes FormatPtr slice
@@ -121,10 +121,7 @@ func checkNGetElem(v reflect.Value) reflect.Value {
 
 //VideoInfo Descibes Video's Informations (get_video_info?video_id=(videoID))
 type VideoInfo struct {
-	PlayabilityStatus struct {
-		Status          string `json:"status"`
-		PlayableInEmbed bool   `json:"playableInEmbed"`
-	} `json:"playabilityStatus"`
+	PlayabilityStatus PlayabilityStatus `json:"playabilityStatus"`
 
 	StreamingData struct {
 		ExpiresInSeconds string     `json:"expiresInSeconds"`
@@ -132,50 +129,67 @@ type VideoInfo struct {
 		AdaptiveFormats  FormatList `json:"adaptiveFormats"`
 	} `json:"streamingData"`
 
-	VideoDetails struct {
-		VideoID           string    `json:"videoId"`
-		Title             string    `json:"title"`
-		LengthSeconds     string    `json:"lengthSeconds"`
-		Keywords          []string  `json:"keywords"`
-		ChannelID         string    `json:"channelId"`
-		IsOwnerViewing    bool      `json:"isOwnerViewing"`
-		IsCrawlable       bool      `json:"isCrawlable"`
-		ShortDescription  string    `json:"shortDescription"`
-		Thumbnail         Thumbnail `json:"thumbnail"`
-		AverageRating     float64   `json:"averageRating"`
-		AllowRatings      bool      `json:"allowRatings"`
-		ViewCount         string    `json:"viewCount"`
-		Author            string    `json:"author"`
-		IsPrivate         bool      `json:"isPrivate"`
-		IsUnpluggedCorpus bool      `json:"isUnpluggedCorpus"`
-		IsLiveContent     bool      `json:"isLiveContent"`
-	} `json:"videoDetails"`
+	VideoDetails VideoDetails `json:"videoDetails"`
 
 	Microformat struct {
-		PlayerMicroformatRenderer struct {
-			Thumbnail Thumbnail `json:"thumbnail"`
-			Embed     struct {
-				IframeURL      string `json:"iframeUrl"`
-				FlashURL       string `json:"flashUrl"`
-				Width          int    `json:"width"`
-				Height         int    `json:"height"`
-				FlashSecureURL string `json:"flashSecureUrl"`
-			} `json:"embed"`
-			Title              SimpleText `json:"title"`
-			Description        SimpleText `json:"description"`
-			LengthSeconds      string     `json:"lengthSeconds"`
-			OwnerProfileURL    string     `json:"ownerProfileUrl"`
-			ExternalChannelID  string     `json:"externalChannelId"`
-			AvailableCountries []string   `json:"availableCountries"`
-			IsUnlisted         bool       `json:"isUnlisted"`
-			HasYpcMetadata     bool       `json:"hasYpcMetadata"`
-			ViewCount          string     `json:"viewCount"`
-			Category           string     `json:"category"`
-			PublishDate        string     `json:"publishDate"`
-			OwnerChannelName   string     `json:"ownerChannelName"`
-			UploadDate         string     `json:"uploadDate"`
-		} `json:"playerMicroformatRenderer"`
+		PlayerMicroformatRenderer PlayerMicroformatRenderer `json:"playerMicroformatRenderer"`
 	} `json:"microformat"`
+
+	Captions struct {
+		PlayerCaptionsTracklistRenderer struct {
+			CaptionTracks []CaptionTrack `json:"captionTracks"`
+		} `json:"playerCaptionsTracklistRenderer"`
+	} `json:"captions"`
+
+	//FetchedAt is when player response was fetched from Youtube (zero if unknown)
+	FetchedAt time.Time `json:"-"`
+
+	client *Client
+}
+
+//VideoDetails describes videoDetails JSON type
+type VideoDetails struct {
+	VideoID           string    `json:"videoId"`
+	Title             string    `json:"title"`
+	LengthSeconds     string    `json:"lengthSeconds"`
+	Keywords          []string  `json:"keywords"`
+	ChannelID         string    `json:"channelId"`
+	IsOwnerViewing    bool      `json:"isOwnerViewing"`
+	IsCrawlable       bool      `json:"isCrawlable"`
+	ShortDescription  string    `json:"shortDescription"`
+	Thumbnail         Thumbnail `json:"thumbnail"`
+	AverageRating     float64   `json:"averageRating"`
+	AllowRatings      bool      `json:"allowRatings"`
+	ViewCount         string    `json:"viewCount"`
+	Author            string    `json:"author"`
+	IsPrivate         bool      `json:"isPrivate"`
+	IsUnpluggedCorpus bool      `json:"isUnpluggedCorpus"`
+	IsLiveContent     bool      `json:"isLiveContent"`
+}
+
+//PlayerMicroformatRenderer describes playerMicroformatRenderer JSON type
+type PlayerMicroformatRenderer struct {
+	Thumbnail Thumbnail `json:"thumbnail"`
+	Embed     struct {
+		IframeURL      string `json:"iframeUrl"`
+		FlashURL       string `json:"flashUrl"`
+		Width          int    `json:"width"`
+		Height         int    `json:"height"`
+		FlashSecureURL string `json:"flashSecureUrl"`
+	} `json:"embed"`
+	Title              SimpleText `json:"title"`
+	Description        SimpleText `json:"description"`
+	LengthSeconds      string     `json:"lengthSeconds"`
+	OwnerProfileURL    string     `json:"ownerProfileUrl"`
+	ExternalChannelID  string     `json:"externalChannelId"`
+	AvailableCountries []string   `json:"availableCountries"`
+	IsUnlisted         bool       `json:"isUnlisted"`
+	HasYpcMetadata     bool       `json:"hasYpcMetadata"`
+	ViewCount          string     `json:"viewCount"`
+	Category           string     `json:"category"`
+	PublishDate        string     `json:"publishDate"`
+	OwnerChannelName   string     `json:"ownerChannelName"`
+	UploadDate         string     `json:"uploadDate"`
 }
 
 //Format describes video type format
@@ -195,6 +209,10 @@ type Format struct {
 	AverageBitrate    int    `json:"averageBitrate"`
 	ApproxDurationsMs string `json:"approxDurationsMs,omitempty"`
 	SignatureCipher   string `json:"signatureCipher,omitempty"`
+	//InitRange and IndexRange are byte ranges of initialization segment
+	//and index (sidx or Cues) of adaptive formats
+	InitRange  *ByteRange `json:"initRange,omitempty"`
+	IndexRange *ByteRange `json:"indexRange,omitempty"`
 	FormatAudio
 
 	//User Field (JSON Ignored)
@@ -256,8 +274,24 @@ type FormatAudio struct {
 }
 
 //SimpleText for Simplize structs
+//
+//Some texts are sent as runs instead of simpleText
 type SimpleText struct {
 	SimpleText string `json:"simpleText"`
+	Runs       []struct {
+		Text string `json:"text"`
+	} `json:"runs,omitempty"`
+}
+
+func (t SimpleText) String() string {
+	if t.SimpleText != "" || len(t.Runs) == 0 {
+		return t.SimpleText
+	}
+	var sb strings.Builder
+	for _, r := range t.Runs {
+		sb.WriteString(r.Text)
+	}
+	return sb.String()
 }
 
 //Thumbnail describes thumbnail JSON type
@@ -269,26 +303,45 @@ type Thumbnail struct {
 	} `json:"thumbnails"`
 }
 
-//GetVideoInfo Gets get_video_info file From Youtube
+//GetVideoInfo Gets get_video_info file From Youtube using DefaultClient
+//
+//Returned errors are *ytdlerrors.Error, unplayable videos wrap *PlayabilityError
 func GetVideoInfo(VIDorURL string) (*VideoInfo, error) {
+	return DefaultClient.GetVideoInfo(VIDorURL)
+}
 
+//GetVideoInfo Gets get_video_info file From Youtube
+//
+//Returned errors are *ytdlerrors.Error, unplayable videos wrap *PlayabilityError
+func (c *Client) GetVideoInfo(VIDorURL string) (*VideoInfo, error) {
 	VID, err := getVideoIDFromURL(VIDorURL)
 	if err != nil {
-		return nil, err
+		return nil, e.Wrap(err, "GetVideoInfo", VIDorURL, 0)
 	}
 
-	URL := fmt.Sprintf(getVideoInfoURL, VID)
+	if c.UseCache || c.Offline {
+		vi, err := c.loadCachedVideoInfo(VID)
+		if err == nil || c.Offline {
+			return vi, e.Wrap(err, "GetVideoInfo", VID, 0)
+		}
+		logger.Debug(c.Logger, "not using cached video info", "videoID", VID, "reason", err)
+	}
 
-	res, err := http.Get(URL)
+	vi, err := c.getVideoInfo(VID)
 	if err != nil {
-		return nil, e.DbgErr(err)
+		return nil, e.Wrap(err, "GetVideoInfo", VID, 0)
 	}
+	return vi, nil
+}
 
-	data, err := ioutil.ReadAll(res.Body)
+func (c *Client) getVideoInfo(VID string) (*VideoInfo, error) {
+	URL := fmt.Sprintf(getVideoInfoURL, VID)
+	logger.Debug(c.Logger, "getting video info", "videoID", VID)
+
+	data, err := c.downloader(VID).Get(URL)
 	if err != nil {
 		return nil, e.DbgErr(err)
 	}
-	defer res.Body.Close()
 
 	temp, err := url.QueryUnescape(string(data))
 	if err != nil {
@@ -297,31 +350,82 @@ func GetVideoInfo(VIDorURL string) (*VideoInfo, error) {
 
 	r := regexp.MustCompile(`player_response=({.*})`)
 	matches := r.FindStringSubmatch(temp)
+	if matches == nil {
+		return nil, e.DbgErr(unexpectedResponseErr(data))
+	}
 
 	rawJSON := []byte(matches[1])
+	fetchedAt := time.Now()
 
-	err = u.CreatePath(tmpJSONDir)
+	err = c.cacheFile(jsonCacheDir, VID+".json", rawJSON)
 	if err != nil {
 		return nil, e.DbgErr(err)
 	}
 
-	err = ioutil.WriteFile(fmt.Sprintf("%s.json", u.MergePathAndFilename(tmpJSONDir, VID)), rawJSON, 0755)
+	vi, err := c.parseVideoInfo(rawJSON, fetchedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = vi.decipherAll()
 	if err != nil {
 		return nil, e.DbgErr(err)
 	}
 
-	vi := new(VideoInfo)
+	return vi, nil
+}
+
+//Refresh gets VideoInfo again from Youtube and replaces stream URLs of its Formats
+//
+//Formats are updated in place so Formats taken from VideoInfo stay valid.
+//Formats which are not available anymore get empty URL
+func (vi *VideoInfo) Refresh() error {
+	c := vi.client
+	if c == nil {
+		c = DefaultClient
+	}
+	if c.Offline {
+		return e.Wrap(e.ErrCacheExpired, "Refresh", vi.videoID(), 0)
+	}
 
-	err = json.Unmarshal(rawJSON, vi)
+	err := c.checkPlayer(vi)
 	if err != nil {
-		return nil, e.DbgErr(err)
+		return e.Wrap(err, "Refresh", vi.videoID(), 0)
+	}
+	fresh, err := c.getVideoInfo(vi.VideoDetails.VideoID)
+	if err != nil {
+		return e.Wrap(err, "Refresh", vi.videoID(), 0)
 	}
 
-	err = vi.decipherAll()
+	itags := fresh.CombinedFormatList().ToItagMap()
+	for _, f := range vi.CombinedFormatList() {
+		f.URL = ""
+		f.SignatureCipher = ""
+		if nf, ok := itags[f.Itag]; ok {
+			f.URL = nf.URL
+		}
+	}
+	vi.StreamingData.ExpiresInSeconds = fresh.StreamingData.ExpiresInSeconds
+	vi.FetchedAt = fresh.FetchedAt
+	logger.Debug(c.Logger, "video info refreshed", "videoID", vi.videoID(), "expiresAt", vi.ExpiresAt())
+	return nil
+}
+
+//parseVideoInfo unmarshals player response JSON and checks if video is playable
+func (c *Client) parseVideoInfo(rawJSON []byte, fetchedAt time.Time) (*VideoInfo, error) {
+	vi := new(VideoInfo)
+	vi.client = c
+	vi.FetchedAt = fetchedAt
+
+	err := json.Unmarshal(rawJSON, vi)
 	if err != nil {
 		return nil, e.DbgErr(err)
 	}
 
+	err = vi.PlayabilityStatus.Err()
+	if err != nil {
+		return nil, err
+	}
 	return vi, nil
 }
 
@@ -0,0 +1,248 @@
+//Package cookies contains http.CookieJar which can be loaded from and saved to Netscape cookies.txt
+package cookies
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+const httpOnlyPrefix = "#HttpOnly_"
+
+//ErrMalformedLine line of cookies.txt doesn't have 7 fields
+var ErrMalformedLine = e.New(e.ErrYtdl, "malformed cookies.txt line")
+
+type key struct {
+	domain, path, name string
+}
+
+//Jar is http.CookieJar which remembers every attribute of cookies
+//so they can be written back to Netscape cookies.txt
+type Jar struct {
+	//Filename is where Save writes cookies (set by Load)
+	Filename string
+
+	mu      sync.Mutex
+	jar     *cookiejar.Jar
+	entries map[key]*http.Cookie
+}
+
+//New creates empty Jar
+func New() *Jar {
+	jar, _ := cookiejar.New(nil) // never fails without options
+	return &Jar{jar: jar, entries: map[key]*http.Cookie{}}
+}
+
+//Load creates Jar from Netscape cookies.txt
+func Load(filename string) (*Jar, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	defer file.Close()
+
+	j := New()
+	j.Filename = filename
+	err = j.ReadNetscape(file)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	return j, nil
+}
+
+//ReadNetscape adds cookies of Netscape cookies.txt format to Jar
+//
+//	domain	includeSubdomains	path	secure	expiry	name	value
+func (j *Jar) ReadNetscape(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for lineNum := 1; sc.Scan(); lineNum++ {
+		line := strings.TrimRight(sc.Text(), "\r")
+
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = line[len(httpOnlyPrefix):]
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("%w (line %d)", ErrMalformedLine, lineNum)
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w (line %d): %v", ErrMalformedLine, lineNum, err)
+		}
+
+		domain := fields[0]
+		if strings.EqualFold(fields[1], "TRUE") && !strings.HasPrefix(domain, ".") {
+			domain = "." + domain
+		}
+		c := &http.Cookie{
+			Domain:   domain,
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if expiry > 0 {
+			c.Expires = time.Unix(expiry, 0)
+		}
+		j.add(c)
+	}
+	return e.DbgErr(sc.Err())
+}
+
+//add adds cookie whose Domain starts with dot if it's sent to subdomains
+func (j *Jar) add(c *http.Cookie) {
+	host := strings.TrimPrefix(c.Domain, ".")
+	jc := *c
+	if !strings.HasPrefix(c.Domain, ".") {
+		jc.Domain = "" // host-only cookie
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries[key{c.Domain, c.Path, c.Name}] = c
+	j.jar.SetCookies(&url.URL{Scheme: "https", Host: host, Path: c.Path}, []*http.Cookie{&jc})
+}
+
+//SetCookies implements http.CookieJar
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.jar.SetCookies(u, cookies)
+
+	for _, c := range cookies {
+		entry := *c
+		if entry.Domain == "" {
+			entry.Domain = u.Hostname()
+		} else if !strings.HasPrefix(entry.Domain, ".") {
+			entry.Domain = "." + entry.Domain
+		}
+		if entry.Path == "" {
+			entry.Path = "/"
+		}
+		if entry.MaxAge > 0 {
+			entry.Expires = now.Add(time.Duration(entry.MaxAge) * time.Second)
+		}
+
+		k := key{entry.Domain, entry.Path, entry.Name}
+		if entry.MaxAge < 0 || (!entry.Expires.IsZero() && entry.Expires.Before(now)) {
+			delete(j.entries, k)
+			continue
+		}
+		j.entries[k] = &entry
+	}
+}
+
+//Cookies implements http.CookieJar
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.jar.Cookies(u)
+}
+
+//Get returns value of cookie sent to u
+func (j *Jar) Get(u *url.URL, name string) (string, bool) {
+	for _, c := range j.Cookies(u) {
+		if c.Name == name {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
+
+//WriteNetscape writes unexpired cookies in Netscape cookies.txt format
+func (j *Jar) WriteNetscape(w io.Writer) error {
+	now := time.Now()
+
+	j.mu.Lock()
+	entries := make([]*http.Cookie, 0, len(j.entries))
+	for _, c := range j.entries {
+		if c.Expires.IsZero() || c.Expires.After(now) {
+			entries = append(entries, c)
+		}
+	}
+	j.mu.Unlock()
+
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].Domain != entries[b].Domain {
+			return entries[a].Domain < entries[b].Domain
+		}
+		if entries[a].Path != entries[b].Path {
+			return entries[a].Path < entries[b].Path
+		}
+		return entries[a].Name < entries[b].Name
+	})
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# Netscape HTTP Cookie File\n\n")
+	for _, c := range entries {
+		var expiry int64
+		if !c.Expires.IsZero() {
+			expiry = c.Expires.Unix()
+		}
+		prefix := ""
+		if c.HttpOnly {
+			prefix = httpOnlyPrefix
+		}
+		fmt.Fprintf(bw, "%s%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			prefix, c.Domain, netscapeBool(strings.HasPrefix(c.Domain, ".")),
+			c.Path, netscapeBool(c.Secure), expiry, c.Name, c.Value,
+		)
+	}
+	return e.DbgErr(bw.Flush())
+}
+
+//Save writes cookies to Filename
+func (j *Jar) Save() error {
+	return j.SaveFile(j.Filename)
+}
+
+//SaveFile writes cookies to filename in Netscape cookies.txt format
+func (j *Jar) SaveFile(filename string) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	err = j.WriteNetscape(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return e.DbgErr(file.Close())
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+//SAPISIDHash returns value of Authorization header for innertube requests
+//
+//	SAPISIDHASH <timestamp>_<sha1(timestamp + " " + SAPISID + " " + origin)>
+func SAPISIDHash(sapisid, origin string, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	sum := sha1.Sum([]byte(ts + " " + sapisid + " " + origin))
+	return fmt.Sprintf("SAPISIDHASH %s_%x", ts, sum)
+}
@@ -0,0 +1,66 @@
+package cookies_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sam1677/ytdl/internal/cookies"
+)
+
+const cookiesTxt = `# Netscape HTTP Cookie File
+.youtube.com	TRUE	/	TRUE	4102444800	SAPISID	abc
+#HttpOnly_.youtube.com	TRUE	/	TRUE	4102444800	SID	secret
+www.youtube.com	FALSE	/	FALSE	0	PREF	f6=8
+`
+
+func TestNetscape(t *testing.T) {
+	j := cookies.New()
+	err := j.ReadNetscape(strings.NewReader(cookiesTxt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &url.URL{Scheme: "https", Host: "m.youtube.com", Path: "/watch"}
+	if v, ok := j.Get(u, "SAPISID"); !ok || v != "abc" {
+		t.Errorf("SAPISID = %q, %v", v, ok)
+	}
+	if _, ok := j.Get(u, "PREF"); ok {
+		t.Error("host-only cookie is sent to other host")
+	}
+
+	j.SetCookies(u, []*http.Cookie{
+		{Name: "SID", Value: "deleted", Domain: ".youtube.com", Path: "/", MaxAge: -1},
+		{Name: "VISITOR_INFO1_LIVE", Value: "xyz", Domain: ".youtube.com", Path: "/", Expires: time.Unix(4102444800, 0)},
+	})
+
+	buf := new(bytes.Buffer)
+	err = j.WriteNetscape(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		".youtube.com\tTRUE\t/\tTRUE\t4102444800\tSAPISID\tabc\n",
+		".youtube.com\tTRUE\t/\tFALSE\t4102444800\tVISITOR_INFO1_LIVE\txyz\n",
+		"www.youtube.com\tFALSE\t/\tFALSE\t0\tPREF\tf6=8\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%q is not written:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "\tSID\t") {
+		t.Errorf("deleted cookie is written:\n%s", out)
+	}
+}
+
+func TestSAPISIDHash(t *testing.T) {
+	got := cookies.SAPISIDHash("abc", "https://www.youtube.com", time.Unix(1600000000, 0))
+	want := "SAPISIDHASH 1600000000_35eccf1fc44f4b6e1e49f85d14119afbcedf72de"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
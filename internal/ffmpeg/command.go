@@ -0,0 +1,146 @@
+package ffmpeg
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//Command builds ffmpeg arguments from inputs and outputs
+//
+//	cmd := &Command{Overwrite: true}
+//	video := cmd.Input("video.mp4")
+//	audio := cmd.Input("audio.m4a")
+//	cmd.Output("out.mp4").Map(video, "v").Map(audio, "a").Codec("v", "copy").Codec("a", "copy")
+//	err := f.Run(cmd)
+type Command struct {
+	//Args are global options placed before inputs
+	Args []string
+	//Overwrite overwrites existing output files (-y)
+	Overwrite bool
+	Inputs    []*Input
+	Outputs   []*Output
+}
+
+//Input is input file with options placed before -i
+type Input struct {
+	Path string
+	Args []string
+}
+
+//Output is output file with its stream mappings, codecs and options
+type Output struct {
+	Path string
+	//Format forces output container format (-f), empty means guessed from Path
+	Format string
+	Maps   []string
+	Codecs []Codec
+	Args   []string
+}
+
+//Codec selects codec for streams matching Stream specifier (e.g. "v", "a:0", "s")
+type Codec struct {
+	Stream string
+	Name   string
+}
+
+//Input adds input file and returns its index for Output.Map
+func (c *Command) Input(path string, args ...string) int {
+	c.Inputs = append(c.Inputs, &Input{Path: path, Args: args})
+	return len(c.Inputs) - 1
+}
+
+//Output adds output file
+func (c *Command) Output(path string) *Output {
+	out := &Output{Path: path}
+	c.Outputs = append(c.Outputs, out)
+	return out
+}
+
+//Map maps streams of input matching stream specifier (-map input:stream)
+//
+//Every stream of input is mapped if stream is empty.
+//Trailing "?" makes mapping optional
+func (o *Output) Map(input int, stream string) *Output {
+	spec := strconv.Itoa(input)
+	if stream != "" {
+		spec += ":" + stream
+	}
+	o.Maps = append(o.Maps, spec)
+	return o
+}
+
+//Codec sets codec of streams matching stream specifier (-c:stream name)
+func (o *Output) Codec(stream, name string) *Output {
+	o.Codecs = append(o.Codecs, Codec{Stream: stream, Name: name})
+	return o
+}
+
+//Set appends output options
+func (o *Output) Set(args ...string) *Output {
+	o.Args = append(o.Args, args...)
+	return o
+}
+
+//muxers are ffmpeg muxers which write files of extensions
+var muxers = map[string]string{
+	".mp4":  "mp4",
+	".m4a":  "ipod",
+	".mov":  "mov",
+	".mkv":  "matroska",
+	".mka":  "matroska",
+	".webm": "webm",
+	".mp3":  "mp3",
+	".opus": "opus",
+	".ogg":  "ogg",
+	".flac": "flac",
+	".wav":  "wav",
+	".srt":  "srt",
+	".vtt":  "webvtt",
+	".jpg":  "image2",
+	".jpeg": "image2",
+	".png":  "image2",
+}
+
+//Muxer returns ffmpeg muxer which writes o, or "" if it's unknown
+//
+//Format is used if it's set, otherwise muxer is guessed from extension of Path
+func (o *Output) Muxer() string {
+	if o.Format != "" {
+		return o.Format
+	}
+	return muxers[strings.ToLower(filepath.Ext(o.Path))]
+}
+
+//Build returns arguments of ffmpeg without executable
+func (c *Command) Build() []string {
+	args := []string{"-hide_banner", "-nostdin"}
+	if c.Overwrite {
+		args = append(args, "-y")
+	}
+	args = append(args, c.Args...)
+
+	for _, in := range c.Inputs {
+		args = append(args, in.Args...)
+		args = append(args, "-i", in.Path)
+	}
+
+	for _, out := range c.Outputs {
+		for _, m := range out.Maps {
+			args = append(args, "-map", m)
+		}
+		for _, codec := range out.Codecs {
+			opt := "-c"
+			if codec.Stream != "" {
+				opt += ":" + codec.Stream
+			}
+			args = append(args, opt, codec.Name)
+		}
+		args = append(args, out.Args...)
+		if out.Format != "" {
+			args = append(args, "-f", out.Format)
+		}
+		args = append(args, out.Path)
+	}
+	return args
+}
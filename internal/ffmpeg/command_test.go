@@ -0,0 +1,30 @@
+package ffmpeg_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sam1677/ytdl/internal/ffmpeg"
+)
+
+func TestCommandBuild(t *testing.T) {
+	cmd := &ffmpeg.Command{Overwrite: true}
+	v := cmd.Input("my video.mp4")
+	a := cmd.Input("audio.m4a", "-ss", "10")
+	cmd.Output("out dir/out.mkv").
+		Map(v, "v").Map(a, "a?").
+		Codec("v", "copy").Codec("a", "libopus").
+		Set("-b:a", "128k")
+	cmd.Output("pipe:1").Map(a, "").Codec("", "copy").Format = "matroska"
+
+	want := []string{
+		"-hide_banner", "-nostdin", "-y",
+		"-i", "my video.mp4",
+		"-ss", "10", "-i", "audio.m4a",
+		"-map", "0:v", "-map", "1:a?", "-c:v", "copy", "-c:a", "libopus", "-b:a", "128k", "out dir/out.mkv",
+		"-map", "1", "-c", "copy", "-f", "matroska", "pipe:1",
+	}
+	if got := cmd.Build(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q,\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,158 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/sam1677/ytdl/internal/logger"
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//EnvFFMpeg is environment variable which has path of ffmpeg executable
+const EnvFFMpeg = "FFMPEG"
+
+//Capabilities describes what discovered ffmpeg supports
+type Capabilities struct {
+	//Version is version string printed by ffmpeg -version (e.g. "6.1.1", "N-112000-g...")
+	Version  string
+	Encoders map[string]bool
+	Muxers   map[string]bool
+}
+
+//find finds ffmpeg executable
+//
+//Executable, FFMPEG environment variable and PATH are searched in order
+func (f *FFMpeg) find() (string, error) {
+	if f.Executable != "" {
+		return f.Executable, nil
+	}
+	if env := os.Getenv(EnvFFMpeg); env != "" {
+		return env, nil
+	}
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return "", e.ErrFFMpegNotFound
+	}
+	return path, nil
+}
+
+//Probe runs ffmpeg with -version, -encoders and -muxers and records Capabilities
+func (f *FFMpeg) Probe() error {
+	version, err := f.output("-version")
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	encoders, err := f.output("-encoders")
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	muxers, err := f.output("-muxers")
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	f.Capabilities = &Capabilities{
+		Version:  parseVersion(version),
+		Encoders: parseList(encoders, func(flags string) bool { return true }),
+		Muxers:   parseList(muxers, func(flags string) bool { return strings.Contains(flags, "E") }),
+	}
+	logger.Debug(f.Logger, "ffmpeg probed", "executable", f.Executable, "version", f.Capabilities.Version,
+		"encoders", len(f.Capabilities.Encoders), "muxers", len(f.Capabilities.Muxers))
+	return nil
+}
+
+//HasEncoder reports whether ffmpeg has encoder
+//
+//It returns true if ffmpeg is not probed
+func (f *FFMpeg) HasEncoder(name string) bool {
+	return f.Capabilities == nil || f.Capabilities.Encoders[name]
+}
+
+//HasMuxer reports whether ffmpeg can write format
+//
+//It returns true if ffmpeg is not probed
+func (f *FFMpeg) HasMuxer(name string) bool {
+	return f.Capabilities == nil || f.Capabilities.Muxers[name]
+}
+
+//RequireEncoders returns e.ErrEncoderNotAvailable if one of encoders is not available
+//
+//"copy" is always available
+func (f *FFMpeg) RequireEncoders(names ...string) error {
+	for _, name := range names {
+		if name != "copy" && !f.HasEncoder(name) {
+			return fmt.Errorf("%w: %s (ffmpeg %s)", e.ErrEncoderNotAvailable, name, f.version())
+		}
+	}
+	return nil
+}
+
+//RequireMuxers returns e.ErrMuxerNotAvailable if one of muxers is not available
+func (f *FFMpeg) RequireMuxers(names ...string) error {
+	for _, name := range names {
+		if !f.HasMuxer(name) {
+			return fmt.Errorf("%w: %s (ffmpeg %s)", e.ErrMuxerNotAvailable, name, f.version())
+		}
+	}
+	return nil
+}
+
+func (f *FFMpeg) version() string {
+	if f.Capabilities == nil {
+		return "unknown"
+	}
+	return f.Capabilities.Version
+}
+
+//output runs ffmpeg and returns lines of stdout
+func (f *FFMpeg) output(args ...string) ([]string, error) {
+	var lines []string
+	_, err := f.ExecWithHandle(
+		func(b []byte) error {
+			lines = append(lines, string(b))
+			return nil
+		},
+		f.defaultStderrHandler,
+		defaultDeferFunc,
+		append([]string{f.Executable}, args...)...,
+	)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	return lines, nil
+}
+
+//parseVersion parses "ffmpeg version <version> Copyright ..."
+func parseVersion(lines []string) string {
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[1] == "version" {
+			return fields[2]
+		}
+	}
+	return ""
+}
+
+//parseList parses output of -encoders and -muxers
+//
+//Entries follow a line of dashes and have flags column, names separated by comma and description
+func parseList(lines []string, accept func(flags string) bool) map[string]bool {
+	list := map[string]bool{}
+	started := false
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if !started {
+			started = len(fields) == 1 && strings.Trim(fields[0], "-") == ""
+			continue
+		}
+		if len(fields) < 2 || !accept(fields[0]) {
+			continue
+		}
+		for _, name := range strings.Split(fields[1], ",") {
+			list[name] = true
+		}
+	}
+	return list
+}
@@ -0,0 +1,93 @@
+package ffmpeg_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/sam1677/ytdl/internal/ffmpeg"
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+const fakeFFMpeg = `#!/bin/sh
+case "$1" in
+-version)
+	echo "ffmpeg version 6.1.1 Copyright (c) 2000-2023 the FFmpeg developers"
+	echo "built with gcc 13"
+	;;
+-encoders)
+	echo "Encoders:"
+	echo " V..... = Video"
+	echo " A..... = Audio"
+	echo " ------"
+	echo " V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC (codec h264)"
+	echo " A....D aac                  AAC (Advanced Audio Coding)"
+	;;
+-muxers)
+	echo "File formats:"
+	echo " D. = Demuxing supported"
+	echo " .E = Muxing supported"
+	echo " --"
+	echo "  E mp4             MP4 (MPEG-4 Part 14)"
+	echo "  E matroska,webm   Matroska / WebM"
+	echo " D  mov,mp4,m4a     QuickTime / MOV"
+	;;
+esac
+`
+
+func TestDiscover(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "ffmpeg-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ffmpeg")
+	err = ioutil.WriteFile(path, []byte(fakeFFMpeg), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv(ffmpeg.EnvFFMpeg, path)
+	defer os.Unsetenv(ffmpeg.EnvFFMpeg)
+
+	f := &ffmpeg.FFMpeg{}
+	err = f.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Executable != path || f.Capabilities.Version != "6.1.1" {
+		t.Errorf("executable %q, version %q", f.Executable, f.Capabilities.Version)
+	}
+	if !f.HasEncoder("libx264") || !f.HasEncoder("aac") || f.HasEncoder("libopus") {
+		t.Errorf("unexpected encoders %v", f.Capabilities.Encoders)
+	}
+	if !f.HasMuxer("webm") || !f.HasMuxer("mp4") || f.HasMuxer("m4a") {
+		t.Errorf("unexpected muxers %v", f.Capabilities.Muxers)
+	}
+
+	cmd := &ffmpeg.Command{}
+	cmd.Output("out.webm").Map(cmd.Input("in.mp4"), "a").Codec("a", "libopus")
+	if err := f.Run(cmd); !errors.Is(err, e.ErrEncoderNotAvailable) {
+		t.Errorf("missing encoder is not reported: %v", err)
+	}
+
+	cmd = &ffmpeg.Command{}
+	cmd.Output("out.m4a").Map(cmd.Input("in.mp4"), "a").Codec("a", "copy")
+	if err := f.Run(cmd); !errors.Is(err, e.ErrMuxerNotAvailable) {
+		t.Errorf("missing muxer is not reported: %v", err)
+	}
+
+	os.Setenv(ffmpeg.EnvFFMpeg, "")
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+"-empty")
+	if err := (&ffmpeg.FFMpeg{}).Init(); !errors.Is(err, e.ErrFFMpegNotFound) {
+		t.Errorf("missing ffmpeg is not reported: %v", err)
+	}
+}
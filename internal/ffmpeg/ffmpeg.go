@@ -2,97 +2,157 @@ package ffmpeg
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 
+	"github.com/sam1677/ytdl/internal/logger"
 	u "github.com/sam1677/ytdl/internal/utils"
-	e "github.com/sam1677/ytdl/internal/ytdlerrors"
+	e "github.com/sam1677/ytdl/ytdlerrors"
 )
 
-var baseDir string
-
-var dbgMode bool = false
-
 //FFMpeg Contains FFMpeg's Executable Path and If it uses Preinstalled Executable
 type FFMpeg struct {
-	Executable            string
+	//Executable is path of ffmpeg. If it's empty, Init finds it
+	Executable string
+	//FFProbe is path of ffprobe. If it's empty, ProbeMedia finds it
+	FFProbe string
+	//UsePreinstalledFFMpeg is kept for compatibility. ffmpeg in PATH is always used if it's found
 	UsePreinstalledFFMpeg bool
+	//DownloadPrebuilt clones ffmpeg-prebuilt into BaseDir if ffmpeg is not found
+	DownloadPrebuilt bool
+	//BaseDir is where ffmpeg-prebuilt is cloned (default: <os.UserCacheDir>/ytdl/ffmpeg-prebuilt)
+	BaseDir string
+	//Logger receives executed commands and their outputs, nil means silent
+	Logger logger.Logger
+	//Capabilities are recorded by Init (Probe)
+	Capabilities *Capabilities
 }
 
-//Init Initializes FFMpeg's Fields and Downloads ffmpeg-prebuilt binaries
+//Init finds ffmpeg and probes its capabilities
+//
+//Executable, FFMPEG environment variable and PATH are searched in order.
+//If ffmpeg is not found and DownloadPrebuilt is set, ffmpeg-prebuilt binaries are downloaded
 func (f *FFMpeg) Init() error {
-	if f.UsePreinstalledFFMpeg {
-		f.Executable = "ffmpeg"
-		return nil
+	path, err := f.find()
+	if err != nil && f.DownloadPrebuilt {
+		path, err = f.downloadPrebuilt()
+	}
+	if err != nil {
+		return e.DbgErr(err)
 	}
+	f.Executable = path
+
+	return e.DbgErr(f.Probe())
+}
 
-	baseDir = "./ffmpeg-prebuilt"
-	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
+//downloadPrebuilt clones ffmpeg-prebuilt into BaseDir and returns path of executable for this platform
+func (f *FFMpeg) downloadPrebuilt() (string, error) {
+	if f.BaseDir == "" {
+		f.BaseDir = defaultBaseDir()
+	}
+	if _, err := os.Stat(f.BaseDir); os.IsNotExist(err) {
+		logger.Info(f.Logger, "downloading ffmpeg-prebuilt", "path", f.BaseDir)
 		_, err := f.ExecWithDefaultHandle(
-			"git", "clone", "https://github.com/sam1677/ffmpeg-prebuilt.git",
+			"git", "clone", "--depth", "1", "https://github.com/sam1677/ffmpeg-prebuilt.git", f.BaseDir,
 		)
 		if err != nil {
-			return err
+			return "", err
 		}
 	}
-	f.Executable = baseDir
+	executable := f.BaseDir
 
 	switch runtime.GOOS {
 	case "windows":
-		f.Executable += "/windows/ffmpeg.exe"
+		executable += "/windows/ffmpeg.exe"
 
 	case "linux":
-		f.Executable += "/linux"
+		executable += "/linux"
 
 		switch runtime.GOARCH {
 		case "386":
-			f.Executable += "/386"
+			executable += "/386"
 		case "amd64":
-			f.Executable += "/amd64"
+			executable += "/amd64"
 		case "arm":
-			f.Executable += "/armhf"
+			executable += "/armhf"
 		case "arm64":
-			f.Executable += "/arm64"
+			executable += "/arm64"
 		}
-		f.Executable += "/ffmpeg"
+		executable += "/ffmpeg"
+
+	default:
+		return "", e.ErrFFMpegNotFound
 	}
 
-	return nil
+	return executable, nil
+}
+
+func defaultBaseDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "ytdl", "ffmpeg-prebuilt")
 }
 
 //MergeVideoNAudio Merges a Video and a Audio into one Video
 func (f *FFMpeg) MergeVideoNAudio(video *os.File, audio *os.File, path, outputFileName string) error {
-	fmt.Println("Start merging video and audio")
-	defer fmt.Println("End merging video and audio")
+	logger.Info(f.Logger, "start merging video and audio", "video", video.Name(), "audio", audio.Name())
+	defer logger.Info(f.Logger, "end merging video and audio", "output", outputFileName)
 
-	err := u.CreatePath(path)
+	err := (&u.Downloader{Logger: f.Logger}).CreatePath(path)
 	if err != nil {
 		return e.DbgErr(err)
 	}
 
-	_, err = f.ExecWithDefaultHandle(
-		//		ffmpeg -i video.mp4 -i audio.mp3 -c:v copy -c:a copy -map 0:v -map 1:a -nostdin -y output.mp4
-		fmt.Sprintf("%s -i %s -i %s -c:v copy -c:a copy -map 0:v -map 1:a -nostdin -y %s",
-			f.Executable, video.Name(), audio.Name(), u.MergePathAndFilename(path, outputFileName),
-		))
+	//ffmpeg -i video.mp4 -i audio.mp3 -map 0:v -map 1:a -c:v copy -c:a copy output.mp4
+	cmd := &Command{Overwrite: true}
+	v := cmd.Input(video.Name())
+	a := cmd.Input(audio.Name())
+	cmd.Output(u.MergePathAndFilename(path, outputFileName)).
+		Map(v, "v").Map(a, "a").
+		Codec("v", "copy").Codec("a", "copy")
 
-	if err != nil {
-		return err
-	}
+	return e.DbgErr(f.Run(cmd))
+}
 
-	return nil
+//Run executes ffmpeg with arguments built by cmd
+//
+//ytdlerrors.ErrEncoderNotAvailable or ytdlerrors.ErrMuxerNotAvailable is returned without executing
+//if ffmpeg lacks codec or container format of cmd
+func (f *FFMpeg) Run(cmd *Command) error {
+	for _, out := range cmd.Outputs {
+		if muxer := out.Muxer(); muxer != "" {
+			if err := f.RequireMuxers(muxer); err != nil {
+				return e.DbgErr(err)
+			}
+		}
+		for _, codec := range out.Codecs {
+			if err := f.RequireEncoders(codec.Name); err != nil {
+				return e.DbgErr(err)
+			}
+		}
+	}
+	_, err := f.ExecWithDefaultHandle(append([]string{f.Executable}, cmd.Build()...)...)
+	return err
 }
 
-//Exec executes command
-//It is different from *os.Command
+//Exec starts args[0] with arguments args[1:]
+//
+//Arguments are passed as they are without shell, so they don't need quoting.
+//stdout and stderr are sent line by line and closed when the process closes them
 func (f *FFMpeg) Exec(args ...string) (cmd *exec.Cmd, stdout <-chan []byte, stderr <-chan []byte, err error) {
-	cmd = exec.Command("/bin/bash", "-c", strings.Join(args, " "))
-	dbgPrintln(cmd)
+	if len(args) == 0 || args[0] == "" {
+		return nil, nil, nil, e.DbgErr(e.New(e.ErrFFMpeg, "executable is empty (Init is not called)"))
+	}
+	cmd = exec.Command(args[0], args[1:]...)
+	logger.Debug(f.Logger, "exec", "args", args)
 
 	sout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -112,7 +172,13 @@ func (f *FFMpeg) Exec(args ...string) (cmd *exec.Cmd, stdout <-chan []byte, stde
 	return cmd, NewChanFromReader(sout), NewChanFromReader(serr), nil
 }
 
-//ExecWithHandle Executes shell command with stdout and stderr handler functions
+//stderrTailLines is how many last lines of stderr are passed to deferFunc
+const stderrTailLines = 8
+
+//ExecWithHandle Executes command with stdout and stderr handler functions
+//
+//deferFunc gets last lines of stderr after every output is read and the process exited.
+//If a handler returns error, the process is killed
 func (f *FFMpeg) ExecWithHandle(
 	stdoutHandler func([]byte) error,
 	stderrHandler func([]byte) error,
@@ -123,101 +189,145 @@ func (f *FFMpeg) ExecWithHandle(
 		return nil, e.DbgErr(err)
 	}
 
-	breaks := make(chan *os.ProcessState)
-	lastError := ""
-
-	go func() {
-		state, _ := cmd.Process.Wait()
-		breaks <- state
-	}()
-
-	for {
+	var tail []string
+	for stdout != nil || stderr != nil {
 		select {
-		case state := <-breaks:
-			err = deferFunc(state, lastError)
-			if err != nil {
-				return nil, err
+		case sout, ok := <-stdout:
+			if !ok {
+				stdout = nil
+				continue
 			}
-
-			return cmd, nil
-		case sout := <-stdout:
 			err = stdoutHandler(sout)
-		case serr := <-stderr:
-			lastError = string(serr)
+		case serr, ok := <-stderr:
+			if !ok {
+				stderr = nil
+				continue
+			}
+			tail = append(tail, string(serr))
+			if len(tail) > stderrTailLines {
+				tail = tail[1:]
+			}
 			err = stderrHandler(serr)
 		}
 		if err != nil {
-			if cmd.Process != nil {
-				cmd.Process.Kill()
-			}
+			cmd.Process.Kill()
+			drain(stdout)
+			drain(stderr)
+			cmd.Wait()
+			return cmd, e.DbgErr(err)
+		}
+	}
+
+	//Wait returns *exec.ExitError for non zero exit code which deferFunc reports
+	if err = cmd.Wait(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
 			return cmd, e.DbgErr(err)
 		}
 	}
+
+	err = deferFunc(cmd.ProcessState, strings.Join(tail, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+func drain(ch <-chan []byte) {
+	if ch == nil {
+		return
+	}
+	for range ch {
+	}
 }
 
-//ExecWithDefaultHandle Executes shell command with default stdout and stderr handler functions
+//ExecWithDefaultHandle Executes command with default stdout and stderr handler functions
 func (f *FFMpeg) ExecWithDefaultHandle(args ...string) (*exec.Cmd, error) {
 	cmd, err := f.ExecWithHandle(
-		defaultStdoutHandler,
-		defaultStderrHandler,
+		f.defaultStdoutHandler,
+		f.defaultStderrHandler,
 		defaultDeferFunc,
 		args...,
 	)
 	return cmd, err
 }
 
+//maxLineSize is maximum length of line sent by NewChanFromReader
+const maxLineSize = 1024 * 1024
+
 //NewChanFromReader creates goroutine which reads line from given io.Reader and sends them to chan
+//
+//Lines are split on \n and \r since ffmpeg rewrites its progress line with \r
 func NewChanFromReader(r io.ReadCloser) <-chan []byte {
 	ch := make(chan []byte)
 	go func() {
-		buf := bufio.NewReader(r)
 		defer r.Close()
 		defer close(ch)
-		for {
-			byt, _, err := buf.ReadLine()
-			if err != nil {
-				break
-			}
-			if len(byt) == 0 {
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 4096), maxLineSize)
+		scanner.Split(scanLines)
+		for scanner.Scan() {
+			if len(scanner.Bytes()) == 0 {
 				continue
 			}
-
+			byt := make([]byte, len(scanner.Bytes()))
+			copy(byt, scanner.Bytes())
 			ch <- byt
 		}
-
+		//Read the rest so the process is not blocked on full pipe
+		io.Copy(ioutil.Discard, r)
 	}()
 	return ch
 }
 
-func defaultStdoutHandler(sout []byte) error {
+//scanLines is bufio.SplitFunc splitting on \r, \n and \r\n
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	for i, b := range data {
+		if b == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if b == '\r' {
+			if i+1 < len(data) {
+				if data[i+1] == '\n' {
+					return i + 2, data[:i], nil
+				}
+				return i + 1, data[:i], nil
+			}
+			if atEOF {
+				return i + 1, data[:i], nil
+			}
+			//\r\n may be split between reads
+			return 0, nil, nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func (f *FFMpeg) defaultStdoutHandler(sout []byte) error {
 	out := strings.TrimSpace(string(sout))
 	if out == "" {
 		return nil
 	}
-	dbgPrintln(out)
+	logger.Debug(f.Logger, out, "stream", "stdout")
 	return nil
 }
 
-func defaultStderrHandler(serr []byte) error {
+func (f *FFMpeg) defaultStderrHandler(serr []byte) error {
 	err := strings.TrimSpace(string(serr))
 	if err == "" {
 		return nil
 	}
-	dbgPrintln(err)
+	logger.Debug(f.Logger, err, "stream", "stderr")
 	return nil
 }
 
 func defaultDeferFunc(state *os.ProcessState, lastError string) error {
 	code := state.ExitCode()
 	if state.Exited() && code != 0 {
-		fmt.Println("code :", code)
-		return e.DbgErr(errors.New(lastError))
+		return e.DbgErr(fmt.Errorf("%w (code %d): %s", e.ErrFFMpegFailed, code, lastError))
 	}
 	return nil
 }
-
-func dbgPrintln(args ...interface{}) {
-	if dbgMode {
-		fmt.Println(args...)
-	}
-}
@@ -1,22 +1,81 @@
 package ffmpeg_test
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sam1677/ytdl/internal/ffmpeg"
+	e "github.com/sam1677/ytdl/ytdlerrors"
 )
 
 func TestInit(t *testing.T) {
-	err := os.RemoveAll("./ffmpeg-prebuilt")
+	if testing.Short() {
+		t.Skip("cloning prebuilt ffmpeg in short mode")
+	}
+	conn, err := net.DialTimeout("tcp", "github.com:443", 5*time.Second)
+	if err != nil {
+		t.Skip("github.com is not reachable:", err)
+	}
+	conn.Close()
+
+	baseDir := filepath.Join(os.TempDir(), "ffmpeg-prebuilt")
+	err = os.RemoveAll(baseDir)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	f := new(ffmpeg.FFMpeg)
+	f := &ffmpeg.FFMpeg{BaseDir: baseDir, DownloadPrebuilt: true}
 	err = f.Init()
 	if err != nil {
 		t.Error(err)
 		return
 	}
 }
+
+//TestHelperProcess is executed as child process by TestExec
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("FFMPEG_TEST_HELPER") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	fmt.Println(strings.Join(args[1:], "|"))
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(os.Stderr, "frame=%d\r", i)
+	}
+	fmt.Fprintln(os.Stderr, "\nfatal: something failed")
+	os.Exit(3)
+}
+
+func TestExec(t *testing.T) {
+	os.Setenv("FFMPEG_TEST_HELPER", "1")
+	defer os.Unsetenv("FFMPEG_TEST_HELPER")
+
+	var stdout []string
+	f := &ffmpeg.FFMpeg{}
+	_, err := f.ExecWithHandle(
+		func(b []byte) error { stdout = append(stdout, string(b)); return nil },
+		func([]byte) error { return nil },
+		func(state *os.ProcessState, lastError string) error {
+			if state.ExitCode() != 3 || !strings.HasSuffix(lastError, "fatal: something failed") {
+				return fmt.Errorf("exit code %d, last error %q", state.ExitCode(), lastError)
+			}
+			return e.ErrFFMpegFailed
+		},
+		os.Args[0], "-test.run=TestHelperProcess", "--", "a b", "$HOME", "c;d",
+	)
+	if !errors.Is(err, e.ErrFFMpegFailed) {
+		t.Fatal(err)
+	}
+	if len(stdout) != 1 || stdout[0] != "a b|$HOME|c;d" {
+		t.Errorf("arguments are changed: %q", stdout)
+	}
+}
@@ -0,0 +1,172 @@
+package ffmpeg
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sam1677/ytdl/internal/logger"
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//EnvFFProbe is environment variable which has path of ffprobe executable
+const EnvFFProbe = "FFPROBE"
+
+//MediaInfo is parsed output of ffprobe -show_format -show_streams
+type MediaInfo struct {
+	Format  FormatInfo   `json:"format"`
+	Streams []StreamInfo `json:"streams"`
+}
+
+//FormatInfo describes container of media file
+type FormatInfo struct {
+	Filename   string            `json:"filename"`
+	FormatName string            `json:"format_name"`
+	NbStreams  int               `json:"nb_streams"`
+	Duration   string            `json:"duration"`
+	Size       string            `json:"size"`
+	BitRate    string            `json:"bit_rate"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+//StreamInfo describes stream of media file
+type StreamInfo struct {
+	Index      int               `json:"index"`
+	CodecName  string            `json:"codec_name"`
+	CodecType  string            `json:"codec_type"`
+	Width      int               `json:"width,omitempty"`
+	Height     int               `json:"height,omitempty"`
+	SampleRate string            `json:"sample_rate,omitempty"`
+	Channels   int               `json:"channels,omitempty"`
+	Duration   string            `json:"duration,omitempty"`
+	BitRate    string            `json:"bit_rate,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+//Codec types of StreamInfo
+const (
+	CodecTypeVideo    = "video"
+	CodecTypeAudio    = "audio"
+	CodecTypeSubtitle = "subtitle"
+)
+
+//DurationSeconds returns duration of container and 0 if it's unknown
+func (mi *MediaInfo) DurationSeconds() float64 {
+	d, _ := strconv.ParseFloat(mi.Format.Duration, 64)
+	return d
+}
+
+//StreamsOf returns streams of codecType
+func (mi *MediaInfo) StreamsOf(codecType string) []StreamInfo {
+	streams := []StreamInfo{}
+	for _, s := range mi.Streams {
+		if s.CodecType == codecType {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+//HasVideo reports whether media has video stream
+func (mi *MediaInfo) HasVideo() bool {
+	return len(mi.StreamsOf(CodecTypeVideo)) > 0
+}
+
+//HasAudio reports whether media has audio stream
+func (mi *MediaInfo) HasAudio() bool {
+	return len(mi.StreamsOf(CodecTypeAudio)) > 0
+}
+
+//findFFProbe finds ffprobe executable
+//
+//FFProbe, FFPROBE environment variable, directory of ffmpeg and PATH are searched in order
+func (f *FFMpeg) findFFProbe() (string, error) {
+	if f.FFProbe != "" {
+		return f.FFProbe, nil
+	}
+	if env := os.Getenv(EnvFFProbe); env != "" {
+		return env, nil
+	}
+	if f.Executable != "" {
+		name := "ffprobe" + strings.TrimPrefix(filepath.Base(f.Executable), "ffmpeg")
+		path := filepath.Join(filepath.Dir(f.Executable), name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	path, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return "", e.ErrFFProbeNotFound
+	}
+	return path, nil
+}
+
+//ProbeMedia runs ffprobe on path and returns MediaInfo
+func (f *FFMpeg) ProbeMedia(path string) (*MediaInfo, error) {
+	ffprobe, err := f.findFFProbe()
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	f.FFProbe = ffprobe
+
+	var out strings.Builder
+	_, err = f.ExecWithHandle(
+		func(b []byte) error {
+			out.Write(b)
+			out.WriteByte('\n')
+			return nil
+		},
+		f.defaultStderrHandler,
+		defaultDeferFunc,
+		ffprobe, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path,
+	)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+
+	info := new(MediaInfo)
+	err = json.Unmarshal([]byte(out.String()), info)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	return info, nil
+}
+
+//durationTolerance is allowed difference between expected and probed duration
+const (
+	durationToleranceSeconds = 2
+	durationToleranceRatio   = 0.01
+)
+
+//ValidateMerged checks that path has video and audio streams
+//and its duration is near expectedSeconds (skipped if expectedSeconds <= 0)
+//
+//It returns ytdlerrors.ErrInvalidOutput if it's not
+func (f *FFMpeg) ValidateMerged(path string, expectedSeconds float64) error {
+	info, err := f.ProbeMedia(path)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	logger.Debug(f.Logger, "probed", "path", path, "format", info.Format.FormatName,
+		"duration", info.Format.Duration, "streams", len(info.Streams))
+
+	switch {
+	case !info.HasVideo():
+		return e.DbgErr(fmt.Errorf("%w: %s has no video stream", e.ErrInvalidOutput, path))
+	case !info.HasAudio():
+		return e.DbgErr(fmt.Errorf("%w: %s has no audio stream", e.ErrInvalidOutput, path))
+	}
+
+	if expectedSeconds > 0 {
+		tolerance := math.Max(durationToleranceSeconds, expectedSeconds*durationToleranceRatio)
+		if d := info.DurationSeconds(); math.Abs(d-expectedSeconds) > tolerance {
+			return e.DbgErr(fmt.Errorf("%w: %s is %.1fs long, expected %.1fs", e.ErrInvalidOutput, path, d, expectedSeconds))
+		}
+	}
+	return nil
+}
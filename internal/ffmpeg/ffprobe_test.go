@@ -0,0 +1,79 @@
+package ffmpeg_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/sam1677/ytdl/internal/ffmpeg"
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+const fakeFFProbe = `#!/bin/sh
+for last; do :; done
+case "$last" in
+*merged.mp4)
+	cat <<'JSON'
+{
+	"streams": [
+		{"index": 0, "codec_name": "h264", "codec_type": "video", "width": 1920, "height": 1080},
+		{"index": 1, "codec_name": "aac", "codec_type": "audio", "sample_rate": "44100", "channels": 2,
+			"tags": {"language": "und"}}
+	],
+	"format": {"filename": "merged.mp4", "format_name": "mov,mp4,m4a,3gp,3g2,mj2", "nb_streams": 2, "duration": "252.613000"}
+}
+JSON
+	;;
+*video.mp4)
+	echo '{"streams": [{"index": 0, "codec_name": "h264", "codec_type": "video"}], "format": {"duration": "252.6"}}'
+	;;
+*)
+	echo "$last: No such file or directory" >&2
+	exit 1
+	;;
+esac
+`
+
+func TestProbeMedia(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffprobe is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "ffprobe-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := &ffmpeg.FFMpeg{FFProbe: filepath.Join(dir, "ffprobe")}
+	err = ioutil.WriteFile(f.FFProbe, []byte(fakeFFProbe), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := f.ProbeMedia("merged.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.HasVideo() || !info.HasAudio() || info.DurationSeconds() != 252.613 {
+		t.Errorf("unexpected media info %+v", info)
+	}
+	if a := info.StreamsOf(ffmpeg.CodecTypeAudio); len(a) != 1 || a[0].Channels != 2 || a[0].Tags["language"] != "und" {
+		t.Errorf("unexpected audio streams %+v", a)
+	}
+
+	if err := f.ValidateMerged("merged.mp4", 253); err != nil {
+		t.Error(err)
+	}
+	if err := f.ValidateMerged("merged.mp4", 300); !errors.Is(err, e.ErrInvalidOutput) {
+		t.Errorf("wrong duration is accepted: %v", err)
+	}
+	if err := f.ValidateMerged("video.mp4", 253); !errors.Is(err, e.ErrInvalidOutput) {
+		t.Errorf("file without audio is accepted: %v", err)
+	}
+	if _, err := f.ProbeMedia("missing.mp4"); !errors.Is(err, e.ErrFFMpegFailed) {
+		t.Errorf("ffprobe failure is not reported: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package ffmpeg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sam1677/ytdl/internal/logger"
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//Default targets of Loudnorm (EBU R128 for streaming)
+const (
+	DefaultIntegrated       = -16
+	DefaultTruePeak         = -1.5
+	DefaultLRA              = 11
+	DefaultSilenceThreshold = "-50dB"
+	//defaultSampleRate is used if sample rate of input is unknown
+	//because loudnorm upsamples to 192kHz
+	defaultSampleRate = 48000
+)
+
+//Loudnorm describes EBU R128 loudness normalization with optional silence trimming
+//and sample rate and channel conversion
+//
+//Zero values use defaults, targets are pointers since 0 is a valid target (e.g. TruePeak of 0 dBTP)
+type Loudnorm struct {
+	//Integrated is target integrated loudness in LUFS (default: DefaultIntegrated)
+	Integrated *float64
+	//TruePeak is maximum true peak in dBTP (default: DefaultTruePeak)
+	TruePeak *float64
+	//LRA is target loudness range in LU (default: DefaultLRA)
+	LRA *float64
+
+	//TrimSilence removes silence at start and end of audio
+	//
+	//Audio is buffered in memory to trim silence at the end
+	TrimSilence bool
+	//SilenceThreshold is level under which audio is silence (default: DefaultSilenceThreshold)
+	SilenceThreshold string
+
+	//SampleRate is sample rate of output (default: sample rate of input, or 48000 if ffprobe is not found)
+	SampleRate int
+	//Channels is number of channels of output (default: channels of input)
+	Channels int
+}
+
+//Loudness is loudness of input measured by the first pass of loudnorm
+type Loudness struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+//targets returns loudnorm options of targets
+func (ln Loudnorm) targets() string {
+	target := func(v *float64, def float64) string {
+		if v == nil {
+			return formatFloat(def)
+		}
+		return formatFloat(*v)
+	}
+	return "I=" + target(ln.Integrated, DefaultIntegrated) +
+		":TP=" + target(ln.TruePeak, DefaultTruePeak) +
+		":LRA=" + target(ln.LRA, DefaultLRA)
+}
+
+//trim returns silenceremove filters trimming start and end, or nil if TrimSilence is not set
+func (ln Loudnorm) trim() []string {
+	if !ln.TrimSilence {
+		return nil
+	}
+	threshold := ln.SilenceThreshold
+	if threshold == "" {
+		threshold = DefaultSilenceThreshold
+	}
+	remove := "silenceremove=start_periods=1:start_threshold=" + threshold
+	return []string{remove, "areverse", remove, "areverse"}
+}
+
+//Filter returns audio filter graph which applies loudnorm with measured loudness (the second pass)
+//
+//If measured is nil, it returns filter graph of the first pass which prints measurement in JSON
+func (ln Loudnorm) Filter(measured *Loudness) string {
+	loudnorm := "loudnorm=" + ln.targets()
+	if measured == nil {
+		loudnorm += ":print_format=json"
+	} else {
+		loudnorm += fmt.Sprintf(":measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true:print_format=summary",
+			measured.InputI, measured.InputTP, measured.InputLRA, measured.InputThresh, measured.TargetOffset)
+	}
+	return strings.Join(append(ln.trim(), loudnorm), ",")
+}
+
+//MeasureLoudness runs the first pass of loudnorm on the first audio stream of path
+func (f *FFMpeg) MeasureLoudness(path string, ln Loudnorm) (*Loudness, error) {
+	cmd := &Command{}
+	in := cmd.Input(path)
+	out := cmd.Output("-").Map(in, "a:0").Set("-filter:a", ln.Filter(nil))
+	out.Format = "null"
+
+	var lines []string
+	_, err := f.ExecWithHandle(
+		f.defaultStdoutHandler,
+		func(b []byte) error {
+			lines = append(lines, string(b))
+			return f.defaultStderrHandler(b)
+		},
+		defaultDeferFunc,
+		append([]string{f.Executable}, cmd.Build()...)...,
+	)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+
+	measured, err := parseLoudness(lines)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	logger.Debug(f.Logger, "loudness measured", "path", path, "integrated", measured.InputI,
+		"truePeak", measured.InputTP, "lra", measured.InputLRA)
+	return measured, nil
+}
+
+//parseLoudness parses the last JSON object printed by loudnorm in stderr lines
+func parseLoudness(lines []string) (*Loudness, error) {
+	start, end := -1, -1
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == "}" && end < 0 {
+			end = i
+		}
+		if line == "{" && end >= 0 {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, e.New(e.ErrFFMpeg, "loudnorm printed no measurement")
+	}
+
+	measured := new(Loudness)
+	err := json.Unmarshal([]byte(strings.Join(lines[start:end+1], "\n")), measured)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	if _, err := strconv.ParseFloat(measured.InputI, 64); err != nil {
+		//silent input is measured as -inf
+		return nil, e.New(e.ErrFFMpeg, "loudness can't be measured: input_i "+measured.InputI)
+	}
+	return measured, nil
+}
+
+//NormalizeLoudness normalizes loudness of audio of in into out with two pass loudnorm
+//
+//Audio is encoded with encoder and other streams are copied
+func (f *FFMpeg) NormalizeLoudness(in, out string, ln Loudnorm, encoder string) error {
+	measured, err := f.MeasureLoudness(in, ln)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	sampleRate := ln.SampleRate
+	if sampleRate == 0 {
+		sampleRate = defaultSampleRate
+		if info, err := f.ProbeMedia(in); err == nil {
+			if a := info.StreamsOf(CodecTypeAudio); len(a) > 0 {
+				if rate, err := strconv.Atoi(a[0].SampleRate); err == nil && rate > 0 {
+					sampleRate = rate
+				}
+			}
+		}
+	}
+
+	cmd := &Command{Overwrite: true}
+	o := cmd.Output(out).Map(cmd.Input(in), "").Codec("", "copy").Codec("a", encoder).
+		Set("-filter:a", ln.Filter(measured), "-ar", strconv.Itoa(sampleRate))
+	if ln.Channels > 0 {
+		o.Set("-ac", strconv.Itoa(ln.Channels))
+	}
+	return e.DbgErr(f.Run(cmd))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,105 @@
+package ffmpeg_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sam1677/ytdl/internal/ffmpeg"
+)
+
+//fakeLoudnorm prints measurement of the first pass and logs arguments of the second one
+const fakeLoudnorm = `#!/bin/sh
+case "$*" in
+*" -f null -")
+	cat >&2 <<'JSON'
+[Parsed_loudnorm_0 @ 0x5581] 
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-16.58",
+	"output_tp" : "-1.50",
+	"output_lra" : "14.78",
+	"output_thresh" : "-27.71",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.58"
+}
+JSON
+	;;
+*)
+	echo "$@" > "$FFMPEG_LOG"
+	;;
+esac
+`
+
+func TestLoudnormFilter(t *testing.T) {
+	integrated, truePeak := -14.0, 0.0
+	ln := ffmpeg.Loudnorm{Integrated: &integrated, TruePeak: &truePeak, TrimSilence: true}
+	want := "silenceremove=start_periods=1:start_threshold=-50dB,areverse," +
+		"silenceremove=start_periods=1:start_threshold=-50dB,areverse," +
+		"loudnorm=I=-14:TP=0:LRA=11:print_format=json"
+	if got := ln.Filter(nil); got != want {
+		t.Errorf("first pass filter\n got %s\nwant %s", got, want)
+	}
+
+	measured := &ffmpeg.Loudness{InputI: "-27.61", InputTP: "-4.47", InputLRA: "18.06", InputThresh: "-39.20", TargetOffset: "0.58"}
+	want = "loudnorm=I=-16:TP=-1.5:LRA=11:measured_I=-27.61:measured_TP=-4.47:measured_LRA=18.06:" +
+		"measured_thresh=-39.20:offset=0.58:linear=true:print_format=summary"
+	if got := (ffmpeg.Loudnorm{}).Filter(measured); got != want {
+		t.Errorf("second pass filter\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestNormalizeLoudness(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "loudnorm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := &ffmpeg.FFMpeg{Executable: filepath.Join(dir, "ffmpeg"), FFProbe: filepath.Join(dir, "ffprobe")}
+	err = ioutil.WriteFile(f.Executable, []byte(fakeLoudnorm), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(f.FFProbe, []byte(fakeFFProbe), 0755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "ffmpeg.log")
+	os.Setenv("FFMPEG_LOG", log)
+	defer os.Unsetenv("FFMPEG_LOG")
+
+	measured, err := f.MeasureLoudness("merged.mp4", ffmpeg.Loudnorm{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if measured.InputI != "-27.61" || measured.TargetOffset != "0.58" {
+		t.Errorf("unexpected measurement %+v", measured)
+	}
+
+	err = f.NormalizeLoudness("merged.mp4", "out.mp4", ffmpeg.Loudnorm{Channels: 1}, "aac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"-i merged.mp4 -map 0 -c copy -c:a aac -filter:a loudnorm=",
+		":measured_I=-27.61:",
+		" -ar 44100 -ac 1 out.mp4",
+	} {
+		if !strings.Contains(string(logged), want) {
+			t.Errorf("ffmpeg is not run with %q:\n%s", want, logged)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+//Package logger contains leveled structured Logger used across ytdl
+package logger
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Level is log message's severity
+type Level int
+
+const (
+	//LevelDebug verbose tracing (cipher steps, executed commands)
+	LevelDebug Level = iota
+	//LevelInfo progress of operations
+	LevelInfo
+	//LevelWarn recoverable failures
+	LevelWarn
+	//LevelError failures
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+//Logger receives log messages with alternating key and value fields
+//
+//	l.Log(logger.LevelInfo, "start downloading", "itag", 137, "path", path)
+type Logger interface {
+	Log(level Level, msg string, keyvals ...interface{})
+}
+
+//Log logs message to l if l is not nil
+func Log(l Logger, level Level, msg string, keyvals ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.Log(level, msg, keyvals...)
+}
+
+//Debug logs Debug message to l
+func Debug(l Logger, msg string, keyvals ...interface{}) {
+	Log(l, LevelDebug, msg, keyvals...)
+}
+
+//Info logs Info message to l
+func Info(l Logger, msg string, keyvals ...interface{}) {
+	Log(l, LevelInfo, msg, keyvals...)
+}
+
+//Warn logs Warn message to l
+func Warn(l Logger, msg string, keyvals ...interface{}) {
+	Log(l, LevelWarn, msg, keyvals...)
+}
+
+//Error logs Error message to l
+func Error(l Logger, msg string, keyvals ...interface{}) {
+	Log(l, LevelError, msg, keyvals...)
+}
+
+//Writer writes log messages in text format to W
+//
+//	15:04:05.000000 INFO start downloading itag=137 path=Downloads
+type Writer struct {
+	W        io.Writer
+	MinLevel Level
+
+	mu sync.Mutex
+}
+
+//Log implements Logger
+func (w *Writer) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < w.MinLevel {
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(time.Now().Format("15:04:05.000000"))
+	sb.WriteString(" " + level.String() + " " + msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var val interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			val = keyvals[i+1]
+		}
+		fmt.Fprintf(&sb, " %v=%s", keyvals[i], quote(fmt.Sprint(val)))
+	}
+	sb.WriteString("\n")
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	io.WriteString(w.W, sb.String())
+}
+
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
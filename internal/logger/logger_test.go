@@ -0,0 +1,26 @@
+package logger_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sam1677/ytdl/internal/logger"
+)
+
+func TestWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := &logger.Writer{W: buf, MinLevel: logger.LevelInfo}
+
+	logger.Debug(w, "hidden")
+	logger.Info(w, "start downloading", "itag", 137, "file", "a b.mp4")
+	logger.Info(nil, "nil logger is silent")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("message under MinLevel is written: %q", out)
+	}
+	if !strings.Contains(out, `INFO start downloading itag=137 file="a b.mp4"`) {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
@@ -0,0 +1,339 @@
+//Package mkv reads and writes EBML (Matroska, WebM) elements
+//and merges WebM files served by Youtube without ffmpeg
+package mkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//ErrInvalidElement element is truncated or malformed
+var ErrInvalidElement = e.New(e.ErrYtdl, "invalid EBML element")
+
+//Element IDs used by this package
+const (
+	IDEBML               = 0x1A45DFA3
+	IDEBMLVersion        = 0x4286
+	IDEBMLReadVersion    = 0x42F7
+	IDEBMLMaxIDLength    = 0x42F2
+	IDEBMLMaxSizeLength  = 0x42F3
+	IDDocType            = 0x4282
+	IDDocTypeVersion     = 0x4287
+	IDDocTypeReadVersion = 0x4285
+
+	IDSegment      = 0x18538067
+	IDSeekHead     = 0x114D9B74
+	IDSeek         = 0x4DBB
+	IDSeekID       = 0x53AB
+	IDSeekPosition = 0x53AC
+
+	IDInfo          = 0x1549A966
+	IDTimecodeScale = 0x2AD7B1
+	IDDuration      = 0x4489
+	IDMuxingApp     = 0x4D80
+	IDWritingApp    = 0x5741
+
+	IDTracks      = 0x1654AE6B
+	IDTrackEntry  = 0xAE
+	IDTrackNumber = 0xD7
+	IDTrackUID    = 0x73C5
+	IDTrackType   = 0x83
+	IDCodecID     = 0x86
+	IDLanguage    = 0x22B59C
+
+	IDCluster        = 0x1F43B675
+	IDTimecode       = 0xE7
+	IDSimpleBlock    = 0xA3
+	IDBlockGroup     = 0xA0
+	IDBlock          = 0xA1
+	IDReferenceBlock = 0xFB
+
+	IDCues               = 0x1C53BB6B
+	IDCuePoint           = 0xBB
+	IDCueTime            = 0xB3
+	IDCueTrackPositions  = 0xB7
+	IDCueTrack           = 0xF7
+	IDCueClusterPosition = 0xF1
+
+	IDVoid = 0xEC
+)
+
+//masters are elements whose payload is parsed into Children
+var masters = map[uint32]bool{
+	IDEBML: true, IDSegment: true, IDSeekHead: true, IDSeek: true, IDInfo: true,
+	IDTracks: true, IDTrackEntry: true,
+	IDCluster: true, IDBlockGroup: true, IDCues: true, IDCuePoint: true, IDCueTrackPositions: true,
+}
+
+//unknownSize is size of element whose size is unknown (live streams)
+const unknownSize = -1
+
+//maxElementSize is maximum size of element read into memory
+const maxElementSize = 64 * 1024 * 1024
+
+//Element is EBML element
+//
+//Payload of master elements is parsed into Children and Data is nil
+type Element struct {
+	ID       uint32
+	Data     []byte
+	Children []*Element
+}
+
+//Header is header of element
+type Header struct {
+	ID uint32
+	//Size is size of payload, unknownSize (-1) if it's unknown
+	Size int64
+	//HeaderSize is size of ID and size
+	HeaderSize int64
+}
+
+//ReadHeader reads ID and size of element
+//
+//It returns io.EOF if r is at the end
+func ReadHeader(r io.Reader) (*Header, error) {
+	id, n, err := readVint(r, 4, true)
+	if err != nil {
+		return nil, err
+	}
+	size, m, err := readVint(r, 8, false)
+	if err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("%w: truncated header of %X", ErrInvalidElement, id)
+		}
+		return nil, e.DbgErr(err)
+	}
+
+	h := &Header{ID: uint32(id), Size: int64(size), HeaderSize: int64(n + m)}
+	if size == 1<<(7*uint(m))-1 {
+		h.Size = unknownSize
+	}
+	return h, nil
+}
+
+//readVint reads variable length integer of at most maxLen bytes
+//
+//Length marker is kept if keepMarker is set (element IDs)
+func readVint(r io.Reader, maxLen int, keepMarker bool) (uint64, int, error) {
+	var buf [8]byte
+	_, err := io.ReadFull(r, buf[:1])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	n := 1
+	for n <= 8 && buf[0]&(0x80>>uint(n-1)) == 0 {
+		n++
+	}
+	if n > maxLen {
+		return 0, 0, e.DbgErr(fmt.Errorf("%w: vint of %d bytes", ErrInvalidElement, n))
+	}
+	_, err = io.ReadFull(r, buf[1:n])
+	if err != nil {
+		return 0, 0, e.DbgErr(fmt.Errorf("%w: truncated vint", ErrInvalidElement))
+	}
+
+	v := uint64(buf[0])
+	if !keepMarker {
+		v &= uint64(0xff >> uint(n))
+	}
+	for _, b := range buf[1:n] {
+		v = v<<8 | uint64(b)
+	}
+	return v, n, nil
+}
+
+//ReadPayload reads payload of element with header h
+func ReadPayload(r io.Reader, h *Header) (*Element, error) {
+	if h.Size < 0 || h.Size > maxElementSize {
+		return nil, e.DbgErr(fmt.Errorf("%w: %X is too large to read (%d bytes)", ErrInvalidElement, h.ID, h.Size))
+	}
+	data := make([]byte, h.Size)
+	_, err := io.ReadFull(r, data)
+	if err != nil {
+		return nil, e.DbgErr(fmt.Errorf("%w: truncated %X", ErrInvalidElement, h.ID))
+	}
+	return Parse(h.ID, data)
+}
+
+//Parse creates Element from ID and payload
+func Parse(id uint32, data []byte) (*Element, error) {
+	el := &Element{ID: id}
+	if !masters[id] {
+		el.Data = data
+		return el, nil
+	}
+
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		h, err := ReadHeader(r)
+		if err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("%w: truncated child of %X", ErrInvalidElement, id)
+			}
+			return nil, e.DbgErr(err)
+		}
+		if h.Size == unknownSize {
+			h.Size = int64(r.Len())
+		}
+		child, err := ReadPayload(r, h)
+		if err != nil {
+			return nil, err
+		}
+		el.Children = append(el.Children, child)
+	}
+	return el, nil
+}
+
+//NewUint creates unsigned integer element
+func NewUint(id uint32, v uint64) *Element {
+	n := 1
+	for n < 8 && v>>(8*uint(n)) != 0 {
+		n++
+	}
+	data := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		data[i] = byte(v)
+		v >>= 8
+	}
+	return &Element{ID: id, Data: data}
+}
+
+//NewFloat creates 64 bit float element
+func NewFloat(id uint32, v float64) *Element {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, math.Float64bits(v))
+	return &Element{ID: id, Data: data}
+}
+
+//NewString creates string element
+func NewString(id uint32, s string) *Element {
+	return &Element{ID: id, Data: []byte(s)}
+}
+
+//NewMaster creates master element
+func NewMaster(id uint32, children ...*Element) *Element {
+	return &Element{ID: id, Children: children}
+}
+
+//Uint returns payload as unsigned integer
+func (el *Element) Uint() uint64 {
+	var v uint64
+	for _, b := range el.Data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+//Float returns payload as float
+func (el *Element) Float() float64 {
+	switch len(el.Data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(el.Data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(el.Data))
+	}
+	return 0
+}
+
+//Child returns first child with id and nil if there's none
+func (el *Element) Child(id uint32) *Element {
+	for _, c := range el.Children {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+//ChildrenOf returns children with id
+func (el *Element) ChildrenOf(id uint32) []*Element {
+	els := []*Element{}
+	for _, c := range el.Children {
+		if c.ID == id {
+			els = append(els, c)
+		}
+	}
+	return els
+}
+
+//Clone returns deep copy of element
+func (el *Element) Clone() *Element {
+	c := &Element{ID: el.ID}
+	if el.Data != nil {
+		c.Data = append([]byte{}, el.Data...)
+	}
+	for _, child := range el.Children {
+		c.Children = append(c.Children, child.Clone())
+	}
+	return c
+}
+
+//PayloadSize returns size of payload
+func (el *Element) PayloadSize() int64 {
+	size := int64(len(el.Data))
+	for _, c := range el.Children {
+		size += c.Size()
+	}
+	return size
+}
+
+//Size returns size of element when it's written
+func (el *Element) Size() int64 {
+	size := el.PayloadSize()
+	return int64(len(encodeID(el.ID))+len(encodeSize(size, 0))) + size
+}
+
+//WriteTo writes element to w
+func (el *Element) WriteTo(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	el.marshal(buf)
+	return buf.WriteTo(w)
+}
+
+func (el *Element) marshal(buf *bytes.Buffer) {
+	buf.Write(encodeID(el.ID))
+	buf.Write(encodeSize(el.PayloadSize(), 0))
+	buf.Write(el.Data)
+	for _, c := range el.Children {
+		c.marshal(buf)
+	}
+}
+
+//encodeID encodes element ID which contains length marker
+func encodeID(id uint32) []byte {
+	switch {
+	case id >= 1<<24:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 1<<16:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 1<<8:
+		return []byte{byte(id >> 8), byte(id)}
+	}
+	return []byte{byte(id)}
+}
+
+//encodeSize encodes size as vint of length n (minimal length if n is 0)
+func encodeSize(size int64, n int) []byte {
+	if n == 0 {
+		n = 1
+		//all ones is reserved for unknown size
+		for n < 8 && uint64(size) >= 1<<(7*uint(n))-1 {
+			n++
+		}
+	}
+	buf := make([]byte, n)
+	v := uint64(size)
+	for i := n - 1; i >= 0; i-- {
+		buf[i] = byte(v)
+		v >>= 8
+	}
+	buf[0] |= 0x80 >> uint(n-1)
+	return buf
+}
@@ -0,0 +1,113 @@
+package mkv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//Segment is group of clusters referenced by Cues
+type Segment struct {
+	//Start and Duration are in seconds
+	Start    float64
+	Duration float64
+	//Offset and Size are byte range of segment in file
+	Offset int64
+	Size   int64
+}
+
+//ParseIndex parses Cues in index
+//
+//init is the beginning of file containing EBML header and Segment header and Info,
+//contentLength is size of file and duration is duration of media in seconds.
+//Last segment ends at contentLength and duration
+func ParseIndex(init, index []byte, contentLength int64, duration float64) ([]Segment, error) {
+	dataStart, scale, err := parseInit(init)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+
+	r := bytes.NewReader(index)
+	h, err := ReadHeader(r)
+	if err != nil {
+		return nil, e.DbgErr(fmt.Errorf("%w: index is empty", ErrInvalidElement))
+	}
+	if h.ID != IDCues {
+		return nil, e.DbgErr(fmt.Errorf("%w: index is %X, not Cues", ErrInvalidElement, h.ID))
+	}
+	cues, err := ReadPayload(r, h)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+
+	segments := []Segment{}
+	seen := map[int64]bool{}
+	for _, cue := range cues.ChildrenOf(IDCuePoint) {
+		tc, pos := cue.Child(IDCueTime), cue.Child(IDCueTrackPositions)
+		if tc == nil || pos == nil || pos.Child(IDCueClusterPosition) == nil {
+			continue
+		}
+		offset := dataStart + int64(pos.Child(IDCueClusterPosition).Uint())
+		if seen[offset] {
+			continue
+		}
+		seen[offset] = true
+		segments = append(segments, Segment{
+			Start:  float64(tc.Uint()) * float64(scale) / 1e9,
+			Offset: offset,
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Offset < segments[j].Offset })
+
+	for i := range segments {
+		end, endTime := contentLength, duration
+		if i+1 < len(segments) {
+			end, endTime = segments[i+1].Offset, segments[i+1].Start
+		}
+		segments[i].Size = end - segments[i].Offset
+		segments[i].Duration = endTime - segments[i].Start
+	}
+	return segments, nil
+}
+
+//parseInit returns offset of Segment payload and timecode scale
+func parseInit(init []byte) (dataStart int64, scale uint64, err error) {
+	r := bytes.NewReader(init)
+	h, err := ReadHeader(r)
+	if err != nil || h.ID != IDEBML {
+		return 0, 0, e.DbgErr(fmt.Errorf("%w: EBML header is missing", ErrInvalidElement))
+	}
+	_, err = io.CopyN(ioutil.Discard, r, h.Size)
+	if err == nil {
+		h, err = ReadHeader(r)
+	}
+	if err != nil || h.ID != IDSegment {
+		return 0, 0, e.DbgErr(fmt.Errorf("%w: Segment is missing", ErrInvalidElement))
+	}
+	dataStart = int64(len(init) - r.Len())
+
+	scale = timecodeScale
+	for r.Len() > 0 {
+		h, err = ReadHeader(r)
+		if err != nil || h.Size < 0 || h.Size > int64(r.Len()) {
+			break
+		}
+		if h.ID != IDInfo {
+			r.Seek(h.Size, io.SeekCurrent)
+			continue
+		}
+		info, err := ReadPayload(r, h)
+		if err != nil {
+			return 0, 0, e.DbgErr(err)
+		}
+		if s := info.Child(IDTimecodeScale); s != nil && s.Uint() != 0 {
+			scale = s.Uint()
+		}
+		break
+	}
+	return dataStart, scale, nil
+}
@@ -0,0 +1,45 @@
+package mkv_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sam1677/ytdl/internal/mkv"
+)
+
+func TestParseIndex(t *testing.T) {
+	init := new(bytes.Buffer)
+	mkv.NewMaster(mkv.IDEBML, mkv.NewString(mkv.IDDocType, "webm")).WriteTo(init)
+	ebmlSize := init.Len()
+	//Segment of 8 byte size followed by Info with 0.5ms timecode scale
+	init.Write([]byte{0x18, 0x53, 0x80, 0x67, 0x01, 0, 0, 0, 0, 0, 0x10, 0})
+	mkv.NewMaster(mkv.IDInfo, mkv.NewUint(mkv.IDTimecodeScale, 500000)).WriteTo(init)
+	dataStart := int64(ebmlSize + 12)
+
+	cue := func(time, pos uint64) *mkv.Element {
+		return mkv.NewMaster(mkv.IDCuePoint,
+			mkv.NewUint(mkv.IDCueTime, time),
+			mkv.NewMaster(mkv.IDCueTrackPositions, mkv.NewUint(mkv.IDCueTrack, 1), mkv.NewUint(mkv.IDCueClusterPosition, pos)),
+		)
+	}
+	index := new(bytes.Buffer)
+	mkv.NewMaster(mkv.IDCues, cue(0, 100), cue(4000, 600), cue(8000, 1000)).WriteTo(index)
+
+	segments, err := mkv.ParseIndex(init.Bytes(), index.Bytes(), dataStart+1500, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []mkv.Segment{
+		{Start: 0, Duration: 2, Offset: dataStart + 100, Size: 500},
+		{Start: 2, Duration: 2, Offset: dataStart + 600, Size: 400},
+		{Start: 4, Duration: 2, Offset: dataStart + 1000, Size: 500},
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("got %+v", segments)
+	}
+	for i := range want {
+		if segments[i] != want[i] {
+			t.Errorf("segment %d: got %+v, want %+v", i, segments[i], want[i])
+		}
+	}
+}
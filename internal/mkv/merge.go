@@ -0,0 +1,422 @@
+package mkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//ErrUnsupported input is not WebM or Matroska with single track
+var ErrUnsupported = e.New(e.ErrYtdl, "matroska file is not supported (not single track webm)")
+
+//Output timing
+const (
+	//timecodeScale of output is 1ms
+	timecodeScale = 1000000
+	//maxClusterDuration is maximum duration of cluster in timecodeScale
+	maxClusterDuration = 5000
+)
+
+//muxingApp is written to Info
+const muxingApp = "ytdl"
+
+//Merge merges WebM (or Matroska) video and audio into one WebM
+//
+//Both inputs must have single track as Youtube's DASH formats do.
+//Blocks are interleaved by timestamp into new clusters starting at video keyframes.
+//Track numbers are rewritten to 1 (video) and 2 (audio), and Info with duration,
+//Cues of the clusters and SeekHead pointing them are written
+func Merge(w io.WriteSeeker, video, audio io.Reader) error {
+	inputs := []*input{
+		{r: video, number: 1},
+		{r: audio, number: 2},
+	}
+	for _, in := range inputs {
+		err := in.readInit()
+		if err != nil {
+			return e.DbgErr(err)
+		}
+	}
+
+	mw := &writer{w: w}
+	err := mw.writeHeader(inputs)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	for {
+		var next *input
+		for _, in := range inputs {
+			if in.next != nil && (next == nil || in.next.ns < next.next.ns) {
+				next = in
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		err = mw.writeBlock(next.number, next.next)
+		if err != nil {
+			return e.DbgErr(err)
+		}
+		err = next.readBlock()
+		if err != nil {
+			return e.DbgErr(err)
+		}
+	}
+
+	return e.DbgErr(mw.finish())
+}
+
+//input is WebM being merged
+type input struct {
+	r      io.Reader
+	number uint64
+
+	docType string
+	track   *Element
+	//scale is timecode scale in nanoseconds
+	scale uint64
+	//duration is in nanoseconds
+	duration float64
+
+	clusterTimecode int64
+	next            *block
+}
+
+//block is SimpleBlock or BlockGroup with its absolute timestamp
+type block struct {
+	el *Element
+	//ns is timestamp in nanoseconds
+	ns       int64
+	keyframe bool
+}
+
+//readInit reads elements before the first block
+func (in *input) readInit() error {
+	in.scale = timecodeScale
+	err := in.readBlock()
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	if in.track == nil {
+		return e.DbgErr(fmt.Errorf("%w: Tracks is missing", ErrUnsupported))
+	}
+	return nil
+}
+
+//readBlock reads elements until next block
+//
+//Segment and Cluster are entered and elements which are not needed are skipped
+func (in *input) readBlock() error {
+	in.next = nil
+	for {
+		h, err := ReadHeader(in.r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return e.DbgErr(err)
+		}
+
+		switch h.ID {
+		case IDSegment, IDCluster:
+			continue
+
+		case IDEBML, IDInfo, IDTracks, IDTimecode, IDSimpleBlock, IDBlockGroup:
+			el, err := ReadPayload(in.r, h)
+			if err != nil {
+				return e.DbgErr(err)
+			}
+			switch h.ID {
+			case IDEBML:
+				if docType := el.Child(IDDocType); docType != nil {
+					in.docType = string(docType.Data)
+				}
+				if in.docType != "webm" && in.docType != "matroska" {
+					return e.DbgErr(fmt.Errorf("%w: doc type %q", ErrUnsupported, in.docType))
+				}
+			case IDInfo:
+				err = in.parseInfo(el)
+			case IDTracks:
+				entries := el.ChildrenOf(IDTrackEntry)
+				if len(entries) != 1 {
+					return e.DbgErr(fmt.Errorf("%w: %d tracks", ErrUnsupported, len(entries)))
+				}
+				in.track = entries[0]
+			case IDTimecode:
+				in.clusterTimecode = int64(el.Uint())
+			default:
+				in.next, err = in.parseBlock(el)
+				return e.DbgErr(err)
+			}
+			if err != nil {
+				return e.DbgErr(err)
+			}
+
+		default:
+			if h.Size == unknownSize {
+				return e.DbgErr(fmt.Errorf("%w: %X has unknown size", ErrUnsupported, h.ID))
+			}
+			_, err = io.CopyN(ioutil.Discard, in.r, h.Size)
+			if err != nil {
+				return e.DbgErr(fmt.Errorf("%w: truncated %X", ErrInvalidElement, h.ID))
+			}
+		}
+	}
+}
+
+func (in *input) parseInfo(info *Element) error {
+	if scale := info.Child(IDTimecodeScale); scale != nil {
+		in.scale = scale.Uint()
+	}
+	if in.scale == 0 {
+		return e.DbgErr(fmt.Errorf("%w: timecode scale is 0", ErrInvalidElement))
+	}
+	if d := info.Child(IDDuration); d != nil {
+		in.duration = d.Float() * float64(in.scale)
+	}
+	return nil
+}
+
+//parseBlock reads timestamp and keyframe flag of SimpleBlock or BlockGroup
+func (in *input) parseBlock(el *Element) (*block, error) {
+	b := &block{el: el, keyframe: true}
+	data := el.Data
+	if el.ID == IDBlockGroup {
+		inner := el.Child(IDBlock)
+		if inner == nil {
+			return nil, e.DbgErr(fmt.Errorf("%w: BlockGroup without Block", ErrInvalidElement))
+		}
+		data = inner.Data
+		b.keyframe = el.Child(IDReferenceBlock) == nil
+	}
+
+	_, n, err := readVint(bytes.NewReader(data), 8, false)
+	if err != nil || len(data) < n+3 {
+		return nil, e.DbgErr(fmt.Errorf("%w: truncated block", ErrInvalidElement))
+	}
+	rel := int64(int16(binary.BigEndian.Uint16(data[n:])))
+	if el.ID == IDSimpleBlock {
+		b.keyframe = data[n+2]&0x80 != 0
+	}
+	b.ns = (in.clusterTimecode + rel) * int64(in.scale)
+	return b, nil
+}
+
+//writer writes Segment of merged WebM
+type writer struct {
+	w io.WriteSeeker
+	//segmentStart is offset of Segment size and dataStart is offset of its payload
+	segmentStart int64
+	dataStart    int64
+	//pos is offset relative to dataStart
+	pos int64
+
+	seekHeadPos int64
+	infoPos     int64
+	tracksPos   int64
+	info        *Element
+
+	cluster         *Element
+	clusterTimecode int64
+	cues            []*Element
+	lastTimecode    int64
+}
+
+func (mw *writer) write(el *Element) error {
+	n, err := el.WriteTo(mw.w)
+	mw.pos += n
+	return e.DbgErr(err)
+}
+
+//seekHead creates SeekHead with fixed size positions so it can be rewritten in place
+func seekHead(positions map[uint32]int64) *Element {
+	sh := NewMaster(IDSeekHead)
+	for _, id := range []uint32{IDInfo, IDTracks, IDCues} {
+		pos := make([]byte, 8)
+		binary.BigEndian.PutUint64(pos, uint64(positions[id]))
+		sh.Children = append(sh.Children, NewMaster(IDSeek,
+			&Element{ID: IDSeekID, Data: encodeID(id)},
+			&Element{ID: IDSeekPosition, Data: pos},
+		))
+	}
+	return sh
+}
+
+func (mw *writer) writeHeader(inputs []*input) error {
+	header := NewMaster(IDEBML,
+		NewUint(IDEBMLVersion, 1),
+		NewUint(IDEBMLReadVersion, 1),
+		NewUint(IDEBMLMaxIDLength, 4),
+		NewUint(IDEBMLMaxSizeLength, 8),
+		NewString(IDDocType, inputs[0].docType),
+		NewUint(IDDocTypeVersion, 4),
+		NewUint(IDDocTypeReadVersion, 2),
+	)
+	_, err := header.WriteTo(mw.w)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	//Segment size is written by finish
+	mw.segmentStart, err = mw.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	mw.segmentStart += int64(len(encodeID(IDSegment)))
+	_, err = mw.w.Write(append(encodeID(IDSegment), encodeSize(0, 8)...))
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	mw.dataStart = mw.segmentStart + 8
+
+	mw.seekHeadPos = mw.pos
+	err = mw.write(seekHead(nil))
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	var duration float64
+	for _, in := range inputs {
+		duration = math.Max(duration, in.duration)
+	}
+	mw.info = NewMaster(IDInfo,
+		NewUint(IDTimecodeScale, timecodeScale),
+		NewFloat(IDDuration, duration/timecodeScale),
+		NewString(IDMuxingApp, muxingApp),
+		NewString(IDWritingApp, muxingApp),
+	)
+	mw.infoPos = mw.pos
+	err = mw.write(mw.info)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	tracks := NewMaster(IDTracks)
+	for _, in := range inputs {
+		entry := in.track.Clone()
+		for i, c := range entry.Children {
+			switch c.ID {
+			case IDTrackNumber, IDTrackUID:
+				entry.Children[i] = NewUint(c.ID, in.number)
+			}
+		}
+		tracks.Children = append(tracks.Children, entry)
+	}
+	mw.tracksPos = mw.pos
+	return e.DbgErr(mw.write(tracks))
+}
+
+//writeBlock adds block to current cluster or starts new cluster
+func (mw *writer) writeBlock(track uint64, b *block) error {
+	timecode := int64(math.Round(float64(b.ns) / timecodeScale))
+	rel := timecode - mw.clusterTimecode
+	newCluster := mw.cluster == nil ||
+		(track == 1 && b.keyframe) ||
+		rel > maxClusterDuration || rel < math.MinInt16
+
+	if newCluster {
+		err := mw.flushCluster()
+		if err != nil {
+			return e.DbgErr(err)
+		}
+		mw.cluster = NewMaster(IDCluster, NewUint(IDTimecode, uint64(timecode)))
+		mw.clusterTimecode = timecode
+		rel = 0
+
+		if b.keyframe && (track == 1 || len(mw.cues) == 0) {
+			mw.cues = append(mw.cues, NewMaster(IDCuePoint,
+				NewUint(IDCueTime, uint64(timecode)),
+				NewMaster(IDCueTrackPositions,
+					NewUint(IDCueTrack, track),
+					NewUint(IDCueClusterPosition, uint64(mw.pos)),
+				),
+			))
+		}
+	}
+
+	el := b.el.Clone()
+	target := el
+	if el.ID == IDBlockGroup {
+		target = el.Child(IDBlock)
+	}
+	_, n, err := readVint(bytes.NewReader(target.Data), 8, false)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	data := encodeSize(int64(track), 0)
+	data = append(data, byte(uint16(rel)>>8), byte(uint16(rel)))
+	target.Data = append(data, target.Data[n+2:]...)
+
+	mw.cluster.Children = append(mw.cluster.Children, el)
+	if timecode > mw.lastTimecode {
+		mw.lastTimecode = timecode
+	}
+	return nil
+}
+
+func (mw *writer) flushCluster() error {
+	if mw.cluster == nil {
+		return nil
+	}
+	err := mw.write(mw.cluster)
+	mw.cluster = nil
+	return e.DbgErr(err)
+}
+
+//finish writes last cluster and Cues, and rewrites Segment size, SeekHead and Info
+func (mw *writer) finish() error {
+	err := mw.flushCluster()
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	cuesPos := mw.pos
+	err = mw.write(NewMaster(IDCues, mw.cues...))
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	end := mw.dataStart + mw.pos
+
+	err = mw.rewrite(mw.segmentStart, encodeSize(mw.pos, 8))
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	sh := new(bytes.Buffer)
+	seekHead(map[uint32]int64{IDInfo: mw.infoPos, IDTracks: mw.tracksPos, IDCues: cuesPos}).marshal(sh)
+	err = mw.rewrite(mw.dataStart+mw.seekHeadPos, sh.Bytes())
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	//Duration is taken from the last block if inputs don't have it
+	if mw.info.Child(IDDuration).Float() == 0 {
+		mw.info.Children[1] = NewFloat(IDDuration, float64(mw.lastTimecode))
+		info := new(bytes.Buffer)
+		mw.info.marshal(info)
+		err = mw.rewrite(mw.dataStart+mw.infoPos, info.Bytes())
+		if err != nil {
+			return e.DbgErr(err)
+		}
+	}
+
+	_, err = mw.w.Seek(end, io.SeekStart)
+	return e.DbgErr(err)
+}
+
+//rewrite overwrites data at offset
+func (mw *writer) rewrite(offset int64, data []byte) error {
+	_, err := mw.w.Seek(offset, io.SeekStart)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	_, err = mw.w.Write(data)
+	return e.DbgErr(err)
+}
@@ -0,0 +1,158 @@
+package mkv_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sam1677/ytdl/internal/mkv"
+)
+
+//newWebM creates single track WebM with track number 1
+//
+//Blocks have timestamps (ms) of times, payload "<name><index>"
+//and are split into clusters of 2 blocks
+func newWebM(name, codec string, times []int64, keyframe func(i int) bool) []byte {
+	segment := mkv.NewMaster(mkv.IDSegment,
+		mkv.NewMaster(mkv.IDInfo,
+			mkv.NewUint(mkv.IDTimecodeScale, 1000000),
+			mkv.NewFloat(mkv.IDDuration, 4000),
+		),
+		mkv.NewMaster(mkv.IDTracks, mkv.NewMaster(mkv.IDTrackEntry,
+			mkv.NewUint(mkv.IDTrackNumber, 1),
+			mkv.NewUint(mkv.IDTrackUID, 12345),
+			mkv.NewString(mkv.IDCodecID, codec),
+		)),
+	)
+
+	var cluster *mkv.Element
+	for i, t := range times {
+		if i%2 == 0 {
+			cluster = mkv.NewMaster(mkv.IDCluster, mkv.NewUint(mkv.IDTimecode, uint64(t)))
+			segment.Children = append(segment.Children, cluster)
+		}
+		rel := t - int64(cluster.Child(mkv.IDTimecode).Uint())
+		flags := byte(0)
+		if keyframe(i) {
+			flags = 0x80
+		}
+		data := append([]byte{0x81, byte(rel >> 8), byte(rel), flags}, name...)
+		data = append(data, byte('0'+i))
+		cluster.Children = append(cluster.Children, &mkv.Element{ID: mkv.IDSimpleBlock, Data: data})
+	}
+
+	buf := new(bytes.Buffer)
+	mkv.NewMaster(mkv.IDEBML, mkv.NewString(mkv.IDDocType, "webm")).WriteTo(buf)
+	segment.WriteTo(buf)
+	return buf.Bytes()
+}
+
+func TestMerge(t *testing.T) {
+	video := newWebM("v", "V_VP9", []int64{0, 1000, 2000, 3000}, func(i int) bool { return i%2 == 0 })
+	audio := newWebM("a", "A_OPUS", []int64{0, 500, 1500, 2500, 3500}, func(int) bool { return true })
+
+	file, err := ioutil.TempFile("", "mkv-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	err = mkv.Merge(file, bytes.NewReader(video), bytes.NewReader(audio))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(data)
+	if h, err := mkv.ReadHeader(r); err != nil || h.ID != mkv.IDEBML {
+		t.Fatalf("EBML header: %+v, %v", h, err)
+	} else if _, err := mkv.ReadPayload(r, h); err != nil {
+		t.Fatal(err)
+	}
+	h, err := mkv.ReadHeader(r)
+	if err != nil || h.ID != mkv.IDSegment || h.Size != int64(r.Len()) {
+		t.Fatalf("Segment: %+v, %v (%d bytes left)", h, err, r.Len())
+	}
+	dataStart := int64(len(data) - r.Len())
+	segment, err := mkv.ReadPayload(r, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idAt := func(pos uint64) uint32 {
+		h, err := mkv.ReadHeader(bytes.NewReader(data[dataStart+int64(pos):]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h.ID
+	}
+	for _, seek := range segment.Child(mkv.IDSeekHead).ChildrenOf(mkv.IDSeek) {
+		id := uint32(seek.Child(mkv.IDSeekID).Uint())
+		if got := idAt(seek.Child(mkv.IDSeekPosition).Uint()); got != id {
+			t.Errorf("SeekHead points %X for %X", got, id)
+		}
+	}
+
+	if d := segment.Child(mkv.IDInfo).Child(mkv.IDDuration).Float(); d != 4000 {
+		t.Errorf("duration is %v", d)
+	}
+	entries := segment.Child(mkv.IDTracks).ChildrenOf(mkv.IDTrackEntry)
+	if len(entries) != 2 || entries[1].Child(mkv.IDTrackNumber).Uint() != 2 || string(entries[1].Child(mkv.IDCodecID).Data) != "A_OPUS" {
+		t.Fatalf("unexpected tracks %+v", entries)
+	}
+
+	var blocks []string
+	for _, cluster := range segment.ChildrenOf(mkv.IDCluster) {
+		tc := int64(cluster.Child(mkv.IDTimecode).Uint())
+		for _, b := range cluster.ChildrenOf(mkv.IDSimpleBlock) {
+			rel := int64(int16(uint16(b.Data[1])<<8 | uint16(b.Data[2])))
+			payload := string(b.Data[4:])
+			if (payload[0] == 'v') != (b.Data[0] == 0x81) {
+				t.Errorf("%s has track number %x", payload, b.Data[0])
+			}
+			blocks = append(blocks, payload+"@"+strconv.FormatInt(tc+rel, 10))
+		}
+	}
+	want := "v0@0 a0@0 a1@500 v1@1000 a2@1500 v2@2000 a3@2500 v3@3000 a4@3500"
+	if got := strings.Join(blocks, " "); got != want {
+		t.Errorf("got blocks %s,\nwant %s", got, want)
+	}
+
+	cues := segment.Child(mkv.IDCues).ChildrenOf(mkv.IDCuePoint)
+	if len(cues) != 2 {
+		t.Fatalf("got %d cue points", len(cues))
+	}
+	for _, cue := range cues {
+		pos := cue.Child(mkv.IDCueTrackPositions).Child(mkv.IDCueClusterPosition).Uint()
+		if idAt(pos) != mkv.IDCluster {
+			t.Errorf("cue at %d doesn't point cluster", cue.Child(mkv.IDCueTime).Uint())
+		}
+	}
+}
+
+func TestMergeMultipleTracks(t *testing.T) {
+	buf := new(bytes.Buffer)
+	mkv.NewMaster(mkv.IDEBML, mkv.NewString(mkv.IDDocType, "webm")).WriteTo(buf)
+	mkv.NewMaster(mkv.IDSegment, mkv.NewMaster(mkv.IDTracks,
+		mkv.NewMaster(mkv.IDTrackEntry, mkv.NewUint(mkv.IDTrackNumber, 1)),
+		mkv.NewMaster(mkv.IDTrackEntry, mkv.NewUint(mkv.IDTrackNumber, 2)),
+	)).WriteTo(buf)
+
+	err := mkv.Merge(&discardSeeker{}, bytes.NewReader(buf.Bytes()), bytes.NewReader(buf.Bytes()))
+	if !errors.Is(err, mkv.ErrUnsupported) {
+		t.Errorf("got %v", err)
+	}
+}
+
+type discardSeeker struct{}
+
+func (discardSeeker) Write(p []byte) (int, error)    { return len(p), nil }
+func (discardSeeker) Seek(int64, int) (int64, error) { return 0, nil }
@@ -0,0 +1,309 @@
+//Package mp4 reads and writes ISO base media file format (MP4) boxes
+//and merges fragmented MP4 files served by Youtube without ffmpeg
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//ErrInvalidBox box is truncated or malformed
+var ErrInvalidBox = e.New(e.ErrYtdl, "invalid mp4 box")
+
+//containers are boxes whose payload is parsed into Children
+var containers = map[string]bool{
+	"moov": true, "trak": true, "edts": true, "mdia": true, "minf": true,
+	"dinf": true, "stbl": true, "mvex": true, "moof": true, "traf": true, "udta": true,
+}
+
+//maxBoxSize is maximum size of box read into memory
+const maxBoxSize = 64 * 1024 * 1024
+
+//Box is MP4 box
+//
+//Payload of containers is parsed into Children and Data is nil
+type Box struct {
+	Type     string
+	Data     []byte
+	Children []*Box
+}
+
+//Header is header of box
+type Header struct {
+	Type string
+	//Size is size of the whole box including header, 0 means box extends to the end of file
+	Size int64
+	//HeaderSize is 8 or 16 (64 bit size)
+	HeaderSize int64
+}
+
+//PayloadSize returns size of payload and -1 if box extends to the end of file
+func (h *Header) PayloadSize() int64 {
+	if h.Size == 0 {
+		return -1
+	}
+	return h.Size - h.HeaderSize
+}
+
+//ReadHeader reads header of box
+//
+//It returns io.EOF if r is at the end
+func ReadHeader(r io.Reader) (*Header, error) {
+	var buf [16]byte
+	_, err := io.ReadFull(r, buf[:8])
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, e.DbgErr(fmt.Errorf("%w: truncated header", ErrInvalidBox))
+		}
+		return nil, err
+	}
+
+	h := &Header{
+		Type:       string(buf[4:8]),
+		Size:       int64(binary.BigEndian.Uint32(buf[:4])),
+		HeaderSize: 8,
+	}
+	if h.Size == 1 {
+		_, err = io.ReadFull(r, buf[8:16])
+		if err != nil {
+			return nil, e.DbgErr(fmt.Errorf("%w: truncated header of %s", ErrInvalidBox, h.Type))
+		}
+		h.Size = int64(binary.BigEndian.Uint64(buf[8:16]))
+		h.HeaderSize = 16
+	}
+	if h.Size != 0 && h.Size < h.HeaderSize {
+		return nil, e.DbgErr(fmt.Errorf("%w: %s has size %d", ErrInvalidBox, h.Type, h.Size))
+	}
+	return h, nil
+}
+
+//ReadPayload reads payload of box with header h into Box
+func ReadPayload(r io.Reader, h *Header) (*Box, error) {
+	size := h.PayloadSize()
+	if size < 0 || size > maxBoxSize {
+		return nil, e.DbgErr(fmt.Errorf("%w: %s is too large to read (%d bytes)", ErrInvalidBox, h.Type, h.Size))
+	}
+
+	data := make([]byte, size)
+	_, err := io.ReadFull(r, data)
+	if err != nil {
+		return nil, e.DbgErr(fmt.Errorf("%w: truncated %s", ErrInvalidBox, h.Type))
+	}
+	return Parse(h.Type, data)
+}
+
+//Parse creates Box from type and payload
+func Parse(typ string, data []byte) (*Box, error) {
+	b := &Box{Type: typ}
+	if !containers[typ] {
+		b.Data = data
+		return b, nil
+	}
+
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, e.DbgErr(fmt.Errorf("%w: truncated child of %s", ErrInvalidBox, typ))
+		}
+		size := int64(binary.BigEndian.Uint32(data[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = int64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, e.DbgErr(fmt.Errorf("%w: truncated child of %s", ErrInvalidBox, typ))
+			}
+			size = int64(binary.BigEndian.Uint64(data[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || size > int64(len(data)) {
+			return nil, e.DbgErr(fmt.Errorf("%w: child of %s has size %d", ErrInvalidBox, typ, size))
+		}
+
+		child, err := Parse(string(data[4:8]), data[headerSize:size])
+		if err != nil {
+			return nil, err
+		}
+		b.Children = append(b.Children, child)
+		data = data[size:]
+	}
+	return b, nil
+}
+
+//Size returns size of box when it's written
+func (b *Box) Size() int64 {
+	size := int64(len(b.Data))
+	for _, c := range b.Children {
+		size += c.Size()
+	}
+	if size+8 > 0xffffffff {
+		return size + 16
+	}
+	return size + 8
+}
+
+//WriteTo writes box to w
+func (b *Box) WriteTo(w io.Writer) (int64, error) {
+	if len(b.Type) != 4 {
+		return 0, e.DbgErr(fmt.Errorf("%w: type %q", ErrInvalidBox, b.Type))
+	}
+
+	size := b.Size()
+	header := make([]byte, 8, 16)
+	copy(header[4:], b.Type)
+	if size > 0xffffffff {
+		binary.BigEndian.PutUint32(header, 1)
+		header = header[:16]
+		binary.BigEndian.PutUint64(header[8:], uint64(size))
+	} else {
+		binary.BigEndian.PutUint32(header, uint32(size))
+	}
+
+	n, err := w.Write(header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	n, err = w.Write(b.Data)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+	for _, c := range b.Children {
+		m, err := c.WriteTo(w)
+		written += m
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+//Child returns first child of typ and nil if there's none
+//
+//Nested child can be found with path (e.g. Child("mdia", "mdhd"))
+func (b *Box) Child(path ...string) *Box {
+	cur := b
+	for _, typ := range path {
+		var next *Box
+		for _, c := range cur.Children {
+			if c.Type == typ {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		cur = next
+	}
+	return cur
+}
+
+//ChildrenOf returns children of typ
+func (b *Box) ChildrenOf(typ string) []*Box {
+	boxes := []*Box{}
+	for _, c := range b.Children {
+		if c.Type == typ {
+			boxes = append(boxes, c)
+		}
+	}
+	return boxes
+}
+
+//Remove removes children of typ
+func (b *Box) Remove(typ string) {
+	children := b.Children[:0]
+	for _, c := range b.Children {
+		if c.Type != typ {
+			children = append(children, c)
+		}
+	}
+	b.Children = children
+}
+
+//Clone returns deep copy of box
+func (b *Box) Clone() *Box {
+	c := &Box{Type: b.Type}
+	if b.Data != nil {
+		c.Data = append([]byte{}, b.Data...)
+	}
+	for _, child := range b.Children {
+		c.Children = append(c.Children, child.Clone())
+	}
+	return c
+}
+
+//fullBox returns version and flags of full box
+func (b *Box) fullBox() (version byte, flags uint32, err error) {
+	if len(b.Data) < 4 {
+		return 0, 0, e.DbgErr(fmt.Errorf("%w: truncated %s", ErrInvalidBox, b.Type))
+	}
+	return b.Data[0], binary.BigEndian.Uint32(b.Data[:4]) & 0xffffff, nil
+}
+
+//field reads and writes big endian integers in payload of box
+type field struct {
+	b   *Box
+	err error
+}
+
+func (b *Box) fields() *field {
+	return &field{b: b}
+}
+
+func (f *field) check(off, size int) bool {
+	if f.err != nil {
+		return false
+	}
+	if off < 0 || off+size > len(f.b.Data) {
+		f.err = e.DbgErr(fmt.Errorf("%w: truncated %s", ErrInvalidBox, f.b.Type))
+		return false
+	}
+	return true
+}
+
+func (f *field) u32(off int) uint32 {
+	if !f.check(off, 4) {
+		return 0
+	}
+	return binary.BigEndian.Uint32(f.b.Data[off:])
+}
+
+func (f *field) u64(off int) uint64 {
+	if !f.check(off, 8) {
+		return 0
+	}
+	return binary.BigEndian.Uint64(f.b.Data[off:])
+}
+
+func (f *field) putU32(off int, v uint32) {
+	if f.check(off, 4) {
+		binary.BigEndian.PutUint32(f.b.Data[off:], v)
+	}
+}
+
+func (f *field) putU64(off int, v uint64) {
+	if f.check(off, 8) {
+		binary.BigEndian.PutUint64(f.b.Data[off:], v)
+	}
+}
+
+//uint reads 64 bit integer if version is 1 and 32 bit integer otherwise
+func (f *field) uint(off int, version byte) uint64 {
+	if version == 1 {
+		return f.u64(off)
+	}
+	return uint64(f.u32(off))
+}
+
+func (f *field) putUint(off int, version byte, v uint64) {
+	if version == 1 {
+		f.putU64(off, v)
+		return
+	}
+	f.putU32(off, uint32(v))
+}
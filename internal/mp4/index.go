@@ -0,0 +1,80 @@
+package mp4
+
+import (
+	"bytes"
+	"fmt"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//Segment is media segment referenced by index
+type Segment struct {
+	//Start and Duration are in seconds
+	Start    float64
+	Duration float64
+	//Offset and Size are byte range of segment in file
+	Offset int64
+	Size   int64
+}
+
+//ParseIndex parses sidx in data which is at offset of file
+func ParseIndex(data []byte, offset int64) ([]Segment, error) {
+	r := bytes.NewReader(data)
+	h, err := ReadHeader(r)
+	if err != nil {
+		return nil, e.DbgErr(fmt.Errorf("%w: index is empty", ErrInvalidBox))
+	}
+	if h.Type != "sidx" {
+		return nil, e.DbgErr(fmt.Errorf("%w: index is %s, not sidx", ErrInvalidBox, h.Type))
+	}
+	sidx, err := ReadPayload(r, h)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+
+	v, _, err := sidx.fullBox()
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	f := sidx.fields()
+	timescale := float64(f.u32(8))
+	time := f.uint(12, v)
+	off := 16
+	if v == 1 {
+		off = 20
+	}
+	//first_offset is from the end of sidx
+	pos := offset + h.Size + int64(f.uint(off, v))
+	if v == 1 {
+		off += 8
+	} else {
+		off += 4
+	}
+	count := int(f.u32(off) & 0xffff)
+	if f.err != nil {
+		return nil, e.DbgErr(f.err)
+	}
+	if timescale == 0 {
+		return nil, e.DbgErr(fmt.Errorf("%w: sidx timescale is 0", ErrInvalidBox))
+	}
+
+	segments := make([]Segment, 0, count)
+	for i := 0; i < count; i++ {
+		ref := off + 4 + i*12
+		size := int64(f.u32(ref) & 0x7fffffff)
+		duration := uint64(f.u32(ref + 4))
+		if f.err != nil {
+			return nil, e.DbgErr(f.err)
+		}
+
+		segments = append(segments, Segment{
+			Start:    float64(time) / timescale,
+			Duration: float64(duration) / timescale,
+			Offset:   pos,
+			Size:     size,
+		})
+		time += duration
+		pos += size
+	}
+	return segments, nil
+}
@@ -0,0 +1,688 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//ErrNotFragmented input is not fragmented MP4 with single track
+var ErrNotFragmented = e.New(e.ErrYtdl, "mp4 is not fragmented or has more than one track")
+
+//Flags of tfhd and trun
+const (
+	tfhdBaseDataOffset    = 0x000001
+	tfhdSampleDescription = 0x000002
+	tfhdDefaultDuration   = 0x000008
+
+	trunDataOffset       = 0x000001
+	trunFirstSampleFlags = 0x000004
+	trunSampleDuration   = 0x000100
+	trunSampleSize       = 0x000200
+	trunSampleFlags      = 0x000400
+	trunCompositionTime  = 0x000800
+)
+
+//Merge merges fragmented MP4 video and audio into one fragmented MP4
+//
+//Both inputs must have single track as Youtube's DASH formats do.
+//Output has ftyp of video, moov containing both tracks with edit lists
+//and mvex (mehd, trex) and fragments of both tracks interleaved by decode time.
+//Track IDs are rewritten to 1 (video) and 2 (audio) and sidx boxes are dropped
+func Merge(w io.Writer, video, audio io.Reader) error {
+	inputs := []*input{
+		{r: &countingReader{r: video}, newID: 1},
+		{r: &countingReader{r: audio}, newID: 2},
+	}
+	for _, in := range inputs {
+		err := in.readInit()
+		if err != nil {
+			return e.DbgErr(err)
+		}
+	}
+
+	out := &countingWriter{w: w}
+	_, err := inputs[0].ftyp.WriteTo(out)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	moov, err := mergeMoov(inputs)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	_, err = moov.WriteTo(out)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	seq := uint32(1)
+	for {
+		var next *input
+		for _, in := range inputs {
+			if in.next != nil && (next == nil || in.next.time < next.next.time) {
+				next = in
+			}
+		}
+		if next == nil {
+			return nil
+		}
+
+		err = next.writeFragment(out, seq)
+		if err != nil {
+			return e.DbgErr(err)
+		}
+		seq++
+
+		err = next.readFragment()
+		if err != nil {
+			return e.DbgErr(err)
+		}
+	}
+}
+
+//input is fragmented MP4 being merged
+type input struct {
+	r     *countingReader
+	newID uint32
+
+	ftyp *Box
+	moov *Box
+	trak *Box
+	trex *Box
+
+	trackID        uint32
+	movieTimescale uint32
+	mediaTimescale uint32
+	//duration is in seconds
+	duration float64
+
+	decodeTime uint64
+	next       *fragment
+}
+
+//fragment is moof and header of following mdat whose payload is not read yet
+type fragment struct {
+	moof     *Box
+	moofPos  int64
+	moofSize int64
+	mdat     *Header
+	//time is decode time in seconds
+	time float64
+}
+
+//readInit reads boxes before the first fragment
+func (in *input) readInit() error {
+	var sidx *Box
+	for {
+		h, err := ReadHeader(in.r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return e.DbgErr(err)
+		}
+
+		switch h.Type {
+		case "ftyp", "moov", "sidx":
+			b, err := ReadPayload(in.r, h)
+			if err != nil {
+				return e.DbgErr(err)
+			}
+			switch h.Type {
+			case "ftyp":
+				in.ftyp = b
+			case "moov":
+				in.moov = b
+			default:
+				if sidx == nil {
+					sidx = b
+				}
+			}
+			continue
+
+		case "moof":
+			err = in.parseMoov(sidx)
+			if err != nil {
+				return e.DbgErr(err)
+			}
+			return e.DbgErr(in.readFragmentWith(h))
+
+		case "mdat":
+			return e.DbgErr(fmt.Errorf("%w: mdat before moof", ErrNotFragmented))
+		}
+
+		err = skip(in.r, h)
+		if err != nil {
+			return e.DbgErr(err)
+		}
+	}
+	return e.DbgErr(in.parseMoov(sidx))
+}
+
+//parseMoov finds track of moov and its timescales and duration
+func (in *input) parseMoov(sidx *Box) error {
+	if in.ftyp == nil || in.moov == nil {
+		return e.DbgErr(fmt.Errorf("%w: ftyp or moov is missing", ErrInvalidBox))
+	}
+	traks := in.moov.ChildrenOf("trak")
+	if len(traks) != 1 || in.moov.Child("mvex") == nil {
+		return e.DbgErr(ErrNotFragmented)
+	}
+	in.trak = traks[0]
+
+	tkhd, mvhd, mdhd := in.trak.Child("tkhd"), in.moov.Child("mvhd"), in.trak.Child("mdia", "mdhd")
+	if tkhd == nil || mvhd == nil || mdhd == nil {
+		return e.DbgErr(fmt.Errorf("%w: tkhd, mvhd or mdhd is missing", ErrInvalidBox))
+	}
+
+	var err error
+	in.trackID, err = trackID(tkhd)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	var movieDuration, mediaDuration uint64
+	in.movieTimescale, movieDuration, err = timescale(mvhd)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	in.mediaTimescale, mediaDuration, err = timescale(mdhd)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	if in.movieTimescale == 0 || in.mediaTimescale == 0 {
+		return e.DbgErr(fmt.Errorf("%w: timescale is 0", ErrInvalidBox))
+	}
+
+	for _, trex := range in.moov.Child("mvex").ChildrenOf("trex") {
+		if id := trex.fields().u32(4); id == in.trackID {
+			in.trex = trex
+		}
+	}
+	if in.trex == nil {
+		//trex with version, flags, track_ID, sample description index 1 and zero defaults
+		in.trex = &Box{Type: "trex", Data: make([]byte, 24)}
+		binary.BigEndian.PutUint32(in.trex.Data[8:], 1)
+	}
+
+	switch {
+	case sidx != nil:
+		in.duration, err = sidxDuration(sidx)
+		if err != nil {
+			return e.DbgErr(err)
+		}
+	case in.moov.Child("mvex", "mehd") != nil:
+		mehd := in.moov.Child("mvex", "mehd")
+		v, _, err := mehd.fullBox()
+		if err != nil {
+			return e.DbgErr(err)
+		}
+		f := mehd.fields()
+		in.duration = float64(f.uint(4, v)) / float64(in.movieTimescale)
+		if f.err != nil {
+			return e.DbgErr(f.err)
+		}
+	case mediaDuration != 0:
+		in.duration = float64(mediaDuration) / float64(in.mediaTimescale)
+	default:
+		in.duration = float64(movieDuration) / float64(in.movieTimescale)
+	}
+	return nil
+}
+
+//readFragment reads next moof and header of its mdat
+func (in *input) readFragment() error {
+	in.next = nil
+	for {
+		h, err := ReadHeader(in.r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return e.DbgErr(err)
+		}
+		if h.Type == "moof" {
+			return e.DbgErr(in.readFragmentWith(h))
+		}
+		if h.Type == "mdat" {
+			return e.DbgErr(fmt.Errorf("%w: mdat without moof", ErrInvalidBox))
+		}
+		err = skip(in.r, h)
+		if err != nil {
+			return e.DbgErr(err)
+		}
+	}
+}
+
+//readFragmentWith reads moof whose header h is read and header of following mdat
+func (in *input) readFragmentWith(h *Header) error {
+	frag := &fragment{moofPos: in.r.n - h.HeaderSize, moofSize: h.Size}
+	moof, err := ReadPayload(in.r, h)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	frag.moof = moof
+
+	for {
+		h, err = ReadHeader(in.r)
+		if err == io.EOF {
+			return e.DbgErr(fmt.Errorf("%w: moof without mdat", ErrInvalidBox))
+		}
+		if err != nil {
+			return e.DbgErr(err)
+		}
+		if h.Type == "mdat" {
+			break
+		}
+		err = skip(in.r, h)
+		if err != nil {
+			return e.DbgErr(err)
+		}
+	}
+	if h.Size == 0 {
+		return e.DbgErr(fmt.Errorf("%w: mdat extending to the end of file is not supported", ErrInvalidBox))
+	}
+	frag.mdat = h
+
+	trafs := moof.ChildrenOf("traf")
+	if len(trafs) == 0 {
+		return e.DbgErr(fmt.Errorf("%w: traf is missing", ErrInvalidBox))
+	}
+	if tfdt := trafs[0].Child("tfdt"); tfdt != nil {
+		v, _, err := tfdt.fullBox()
+		if err != nil {
+			return e.DbgErr(err)
+		}
+		f := tfdt.fields()
+		in.decodeTime = f.uint(4, v)
+		if f.err != nil {
+			return e.DbgErr(f.err)
+		}
+	}
+	frag.time = float64(in.decodeTime) / float64(in.mediaTimescale)
+
+	//without tfdt, decode time of next fragment is sum of sample durations
+	d, err := trafDuration(trafs[0], in.trex)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	in.decodeTime += d
+
+	in.next = frag
+	return nil
+}
+
+//writeFragment writes moof with rewritten track ID and sequence number and copies mdat
+func (in *input) writeFragment(w *countingWriter, seq uint32) error {
+	frag := in.next
+	moof := frag.moof
+
+	if mfhd := moof.Child("mfhd"); mfhd != nil {
+		f := mfhd.fields()
+		f.putU32(4, seq)
+		if f.err != nil {
+			return e.DbgErr(f.err)
+		}
+	}
+
+	//data offsets move with moof and by change of its size
+	delta := moof.Size() - frag.moofSize
+	for _, traf := range moof.ChildrenOf("traf") {
+		tfhd := traf.Child("tfhd")
+		if tfhd == nil {
+			return e.DbgErr(fmt.Errorf("%w: tfhd is missing", ErrInvalidBox))
+		}
+		_, flags, err := tfhd.fullBox()
+		if err != nil {
+			return e.DbgErr(err)
+		}
+		f := tfhd.fields()
+		f.putU32(4, in.newID)
+		if flags&tfhdBaseDataOffset != 0 {
+			f.putU64(8, f.u64(8)-uint64(frag.moofPos)+uint64(w.n))
+		}
+		if f.err != nil {
+			return e.DbgErr(f.err)
+		}
+
+		if delta == 0 {
+			continue
+		}
+		for _, trun := range traf.ChildrenOf("trun") {
+			_, flags, err := trun.fullBox()
+			if err != nil {
+				return e.DbgErr(err)
+			}
+			if flags&trunDataOffset != 0 {
+				f := trun.fields()
+				f.putU32(8, uint32(int32(f.u32(8))+int32(delta)))
+				if f.err != nil {
+					return e.DbgErr(f.err)
+				}
+			}
+		}
+	}
+
+	_, err := moof.WriteTo(w)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+
+	header := make([]byte, frag.mdat.HeaderSize)
+	copy(header[4:], "mdat")
+	if frag.mdat.HeaderSize == 16 {
+		binary.BigEndian.PutUint32(header, 1)
+		binary.BigEndian.PutUint64(header[8:], uint64(frag.mdat.Size))
+	} else {
+		binary.BigEndian.PutUint32(header, uint32(frag.mdat.Size))
+	}
+	_, err = w.Write(header)
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	_, err = io.CopyN(w, in.r, frag.mdat.PayloadSize())
+	if err != nil {
+		return e.DbgErr(fmt.Errorf("%w: truncated mdat: %v", ErrInvalidBox, err))
+	}
+	return nil
+}
+
+//mergeMoov creates moov with tracks of inputs
+func mergeMoov(inputs []*input) (*Box, error) {
+	video := inputs[0]
+	timescale := video.movieTimescale
+
+	var duration float64
+	for _, in := range inputs {
+		if in.duration > duration {
+			duration = in.duration
+		}
+	}
+	movieDuration := uint64(duration * float64(timescale))
+
+	mvhd := video.moov.Child("mvhd").Clone()
+	v, _, err := mvhd.fullBox()
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	f := mvhd.fields()
+	f.putUint(durationOffset(v), v, movieDuration)
+	f.putU32(len(mvhd.Data)-4, uint32(len(inputs)+1))
+	if f.err != nil {
+		return nil, e.DbgErr(f.err)
+	}
+
+	moov := &Box{Type: "moov", Children: []*Box{mvhd}}
+	mvex := &Box{Type: "mvex", Children: []*Box{newMehd(movieDuration)}}
+	for _, in := range inputs {
+		trak, err := in.mergedTrak(timescale)
+		if err != nil {
+			return nil, e.DbgErr(err)
+		}
+		moov.Children = append(moov.Children, trak)
+
+		trex := in.trex.Clone()
+		f := trex.fields()
+		f.putU32(4, in.newID)
+		if f.err != nil {
+			return nil, e.DbgErr(f.err)
+		}
+		mvex.Children = append(mvex.Children, trex)
+	}
+	moov.Children = append(moov.Children, mvex)
+
+	//keep udta and others of video
+	for _, c := range video.moov.Children {
+		switch c.Type {
+		case "mvhd", "trak", "mvex":
+		default:
+			moov.Children = append(moov.Children, c.Clone())
+		}
+	}
+	return moov, nil
+}
+
+//mergedTrak returns trak with new track ID and edit list in movie timescale
+func (in *input) mergedTrak(timescale uint32) (*Box, error) {
+	trak := in.trak.Clone()
+	movieDuration := uint64(in.duration * float64(timescale))
+
+	tkhd := trak.Child("tkhd")
+	v, _, err := tkhd.fullBox()
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	f := tkhd.fields()
+	if v == 1 {
+		f.putU32(20, in.newID)
+		f.putU64(28, movieDuration)
+	} else {
+		f.putU32(12, in.newID)
+		f.putU32(20, uint32(movieDuration))
+	}
+	if f.err != nil {
+		return nil, e.DbgErr(f.err)
+	}
+
+	if elst := trak.Child("edts", "elst"); elst != nil {
+		err = rescaleElst(elst, in.movieTimescale, timescale)
+		if err != nil {
+			return nil, e.DbgErr(err)
+		}
+		return trak, nil
+	}
+
+	edts := &Box{Type: "edts", Children: []*Box{newElst(movieDuration)}}
+	children := []*Box{}
+	for _, c := range trak.Children {
+		children = append(children, c)
+		if c.Type == "tkhd" {
+			children = append(children, edts)
+		}
+	}
+	trak.Children = children
+	return trak, nil
+}
+
+//trackID returns track_ID of tkhd
+func trackID(tkhd *Box) (uint32, error) {
+	v, _, err := tkhd.fullBox()
+	if err != nil {
+		return 0, e.DbgErr(err)
+	}
+	f := tkhd.fields()
+	id := f.u32(12)
+	if v == 1 {
+		id = f.u32(20)
+	}
+	return id, e.DbgErr(f.err)
+}
+
+//durationOffset returns offset of duration in mvhd and mdhd
+func durationOffset(version byte) int {
+	if version == 1 {
+		return 24
+	}
+	return 16
+}
+
+//timescale returns timescale and duration of mvhd or mdhd
+func timescale(b *Box) (uint32, uint64, error) {
+	v, _, err := b.fullBox()
+	if err != nil {
+		return 0, 0, e.DbgErr(err)
+	}
+	f := b.fields()
+	ts := f.u32(durationOffset(v) - 4)
+	d := f.uint(durationOffset(v), v)
+	return ts, d, e.DbgErr(f.err)
+}
+
+//sidxDuration returns sum of subsegment durations of sidx in seconds
+func sidxDuration(sidx *Box) (float64, error) {
+	v, _, err := sidx.fullBox()
+	if err != nil {
+		return 0, e.DbgErr(err)
+	}
+	f := sidx.fields()
+	ts := f.u32(8)
+	off := 20
+	if v == 1 {
+		off = 28
+	}
+	count := int(f.u32(off) & 0xffff)
+	var total uint64
+	for i := 0; i < count; i++ {
+		total += uint64(f.u32(off + 4 + i*12 + 4))
+	}
+	if f.err != nil {
+		return 0, e.DbgErr(f.err)
+	}
+	if ts == 0 {
+		return 0, e.DbgErr(fmt.Errorf("%w: sidx timescale is 0", ErrInvalidBox))
+	}
+	return float64(total) / float64(ts), nil
+}
+
+//trafDuration returns sum of sample durations of traf in media timescale
+func trafDuration(traf, trex *Box) (uint64, error) {
+	tfhd := traf.Child("tfhd")
+	if tfhd == nil {
+		return 0, e.DbgErr(fmt.Errorf("%w: tfhd is missing", ErrInvalidBox))
+	}
+	_, flags, err := tfhd.fullBox()
+	if err != nil {
+		return 0, e.DbgErr(err)
+	}
+
+	f := tfhd.fields()
+	defaultDuration := trex.fields().u32(12)
+	if flags&tfhdDefaultDuration != 0 {
+		off := 8
+		if flags&tfhdBaseDataOffset != 0 {
+			off += 8
+		}
+		if flags&tfhdSampleDescription != 0 {
+			off += 4
+		}
+		defaultDuration = f.u32(off)
+	}
+	if f.err != nil {
+		return 0, e.DbgErr(f.err)
+	}
+
+	var total uint64
+	for _, trun := range traf.ChildrenOf("trun") {
+		_, flags, err := trun.fullBox()
+		if err != nil {
+			return 0, e.DbgErr(err)
+		}
+		f := trun.fields()
+		count := int(f.u32(4))
+		if flags&trunSampleDuration == 0 {
+			total += uint64(count) * uint64(defaultDuration)
+			continue
+		}
+
+		off := 8
+		if flags&trunDataOffset != 0 {
+			off += 4
+		}
+		if flags&trunFirstSampleFlags != 0 {
+			off += 4
+		}
+		stride := 0
+		for _, flag := range []uint32{trunSampleDuration, trunSampleSize, trunSampleFlags, trunCompositionTime} {
+			if flags&flag != 0 {
+				stride += 4
+			}
+		}
+		for i := 0; i < count && f.err == nil; i++ {
+			total += uint64(f.u32(off + i*stride))
+		}
+		if f.err != nil {
+			return 0, e.DbgErr(f.err)
+		}
+	}
+	return total, nil
+}
+
+//rescaleElst converts segment durations of elst from timescale from to to
+func rescaleElst(elst *Box, from, to uint32) error {
+	if from == to {
+		return nil
+	}
+	v, _, err := elst.fullBox()
+	if err != nil {
+		return e.DbgErr(err)
+	}
+	f := elst.fields()
+	count := int(f.u32(4))
+	size := 12
+	if v == 1 {
+		size = 20
+	}
+	for i := 0; i < count && f.err == nil; i++ {
+		off := 8 + i*size
+		f.putUint(off, v, f.uint(off, v)*uint64(to)/uint64(from))
+	}
+	return e.DbgErr(f.err)
+}
+
+//newElst creates elst with one edit playing whole media from the start
+func newElst(duration uint64) *Box {
+	elst := &Box{Type: "elst", Data: make([]byte, 8+20)}
+	elst.Data[0] = 1
+	binary.BigEndian.PutUint32(elst.Data[4:], 1)
+	binary.BigEndian.PutUint64(elst.Data[8:], duration)
+	binary.BigEndian.PutUint64(elst.Data[16:], 0)
+	binary.BigEndian.PutUint32(elst.Data[24:], 0x00010000)
+	return elst
+}
+
+//newMehd creates mehd with fragment duration
+func newMehd(duration uint64) *Box {
+	mehd := &Box{Type: "mehd", Data: make([]byte, 12)}
+	mehd.Data[0] = 1
+	binary.BigEndian.PutUint64(mehd.Data[4:], duration)
+	return mehd
+}
+
+//skip skips payload of box
+func skip(r io.Reader, h *Header) error {
+	size := h.PayloadSize()
+	if size < 0 {
+		_, err := io.Copy(ioutil.Discard, r)
+		return e.DbgErr(err)
+	}
+	_, err := io.CopyN(ioutil.Discard, r, size)
+	if err != nil {
+		return e.DbgErr(fmt.Errorf("%w: truncated %s", ErrInvalidBox, h.Type))
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
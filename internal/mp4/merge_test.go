@@ -0,0 +1,189 @@
+package mp4_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/sam1677/ytdl/internal/mp4"
+)
+
+func u32s(vals ...uint32) []byte {
+	b := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(b[i*4:], v)
+	}
+	return b
+}
+
+func box(typ string, data []byte, children ...*mp4.Box) *mp4.Box {
+	return &mp4.Box{Type: typ, Data: data, Children: children}
+}
+
+var matrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+//newTrack creates single track fragmented MP4
+//
+//Fragment i starts at starts[i] (in media timescale) and has samples of duration
+//and mdat payload "<name><i>". If baseOffset is set, tfhd has absolute base data offset
+//and tfdt is omitted
+func newTrack(name, handler string, trackID, timescale uint32, starts []uint32, duration uint32, baseOffset bool) []byte {
+	mvhd := append(u32s(0, 0, 0, 1000, 0, 0x00010000, 0x01000000, 0, 0), u32s(matrix...)...)
+	mvhd = append(mvhd, u32s(0, 0, 0, 0, 0, 0, trackID+1)...)
+	tkhd := append(u32s(3, 0, 0, trackID, 0, 0, 0, 0, 0, 0), u32s(matrix...)...)
+	tkhd = append(tkhd, u32s(0, 0)...)
+	hdlr := append(u32s(0, 0), []byte(handler)...)
+	hdlr = append(hdlr, append(u32s(0, 0, 0), 0)...)
+
+	moov := box("moov", nil,
+		box("mvhd", mvhd),
+		box("trak", nil,
+			box("tkhd", tkhd),
+			box("mdia", nil,
+				box("mdhd", u32s(0, 0, 0, timescale, 0, 0)),
+				box("hdlr", hdlr),
+			),
+		),
+		box("mvex", nil, box("trex", u32s(0, trackID, 1, duration, 0, 0))),
+	)
+	total := uint32(len(starts)) * duration
+	sidx := box("sidx", u32s(0, trackID, timescale, 0, 0, 1, 0, total, 0x90000000))
+
+	buf := new(bytes.Buffer)
+	box("ftyp", []byte("dash\x00\x00\x00\x00iso6mp41")).WriteTo(buf)
+	moov.WriteTo(buf)
+	sidx.WriteTo(buf)
+
+	for i, start := range starts {
+		payload := []byte(name + string(rune('0'+i)))
+		pos := uint32(buf.Len())
+
+		tfhd := box("tfhd", u32s(0x020000, trackID))
+		traf := box("traf", nil, tfhd)
+		if baseOffset {
+			tfhd.Data = u32s(tfhdBaseDataOffset, trackID, 0, pos)
+		} else {
+			traf.Children = append(traf.Children, box("tfdt", u32s(0, start)))
+		}
+		trun := box("trun", u32s(0x000301, 1, 0, duration, uint32(len(payload))))
+		traf.Children = append(traf.Children, trun)
+		moof := box("moof", nil, box("mfhd", u32s(0, uint32(i+1))), traf)
+		binary.BigEndian.PutUint32(trun.Data[8:], uint32(moof.Size()+8))
+
+		moof.WriteTo(buf)
+		box("mdat", payload).WriteTo(buf)
+	}
+	return buf.Bytes()
+}
+
+const tfhdBaseDataOffset = 0x000001
+
+func TestMerge(t *testing.T) {
+	video := newTrack("v", "vide", 1, 90000, []uint32{0, 180000}, 180000, false)
+	audio := newTrack("a", "soun", 1, 44100, []uint32{0, 44100, 88200, 132300}, 44100, true)
+
+	out := new(bytes.Buffer)
+	err := mp4.Merge(out, bytes.NewReader(video), bytes.NewReader(audio))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := out.Bytes()
+
+	var types, payloads []string
+	var moov *mp4.Box
+	r := bytes.NewReader(data)
+	for seq := uint32(1); ; {
+		pos := int64(len(data)) - int64(r.Len())
+		h, err := mp4.ReadHeader(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, h.Type)
+		b, err := mp4.ReadPayload(r, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch h.Type {
+		case "moov":
+			moov = b
+		case "moof":
+			if got := binary.BigEndian.Uint32(b.Child("mfhd").Data[4:]); got != seq {
+				t.Errorf("sequence number %d, want %d", got, seq)
+			}
+			seq++
+
+			tfhd := b.Child("traf", "tfhd").Data
+			base := pos
+			if binary.BigEndian.Uint32(tfhd)&tfhdBaseDataOffset != 0 {
+				base = int64(binary.BigEndian.Uint64(tfhd[8:]))
+			}
+			trun := b.Child("traf", "trun").Data
+			off := base + int64(binary.BigEndian.Uint32(trun[8:]))
+			size := int64(binary.BigEndian.Uint32(trun[16:]))
+			payload := string(data[off : off+size])
+			payloads = append(payloads, payload)
+
+			id := binary.BigEndian.Uint32(tfhd[4:])
+			if (payload[0] == 'v') != (id == 1) {
+				t.Errorf("%s has track ID %d", payload, id)
+			}
+		}
+	}
+
+	want := []string{"v0", "a0", "a1", "v1", "a2", "a3"}
+	if len(payloads) != len(want) {
+		t.Fatalf("got fragments %v, want %v", payloads, want)
+	}
+	for i := range want {
+		if payloads[i] != want[i] {
+			t.Errorf("got fragments %v, want %v", payloads, want)
+			break
+		}
+	}
+	for _, typ := range types {
+		if typ == "sidx" {
+			t.Error("sidx is not dropped")
+		}
+	}
+
+	traks := moov.ChildrenOf("trak")
+	if len(traks) != 2 {
+		t.Fatalf("moov has %d traks", len(traks))
+	}
+	for i, trak := range traks {
+		if id := binary.BigEndian.Uint32(trak.Child("tkhd").Data[12:]); id != uint32(i+1) {
+			t.Errorf("trak %d has ID %d", i, id)
+		}
+		elst := trak.Child("edts", "elst")
+		if elst == nil || binary.BigEndian.Uint64(elst.Data[8:]) != 4000 {
+			t.Errorf("trak %d has edit list %+v", i, elst)
+		}
+	}
+	if trex := moov.Child("mvex").ChildrenOf("trex"); len(trex) != 2 || binary.BigEndian.Uint32(trex[1].Data[4:]) != 2 {
+		t.Errorf("unexpected trex %+v", trex)
+	}
+	if mehd := moov.Child("mvex", "mehd"); mehd == nil || binary.BigEndian.Uint64(mehd.Data[4:]) != 4000 {
+		t.Errorf("unexpected mehd %+v", mehd)
+	}
+	mvhd := moov.Child("mvhd").Data
+	if next := binary.BigEndian.Uint32(mvhd[len(mvhd)-4:]); next != 3 {
+		t.Errorf("next track ID is %d", next)
+	}
+}
+
+func TestMergeNotFragmented(t *testing.T) {
+	buf := new(bytes.Buffer)
+	box("ftyp", []byte("isom\x00\x00\x00\x00")).WriteTo(buf)
+	box("mdat", []byte("data")).WriteTo(buf)
+
+	err := mp4.Merge(new(bytes.Buffer), bytes.NewReader(buf.Bytes()), bytes.NewReader(buf.Bytes()))
+	if !errors.Is(err, mp4.ErrNotFragmented) {
+		t.Errorf("got %v", err)
+	}
+}
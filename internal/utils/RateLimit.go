@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+//TokenBucket limits rate of bytes or requests
+//
+//It's safe for concurrent use, so one TokenBucket can be shared by many downloads
+type TokenBucket struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+//NewTokenBucket creates TokenBucket filled with burst tokens which refills rate tokens per second
+func NewTokenBucket(rate, burst float64) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+//Wait takes n tokens and blocks until they are available
+//
+//Tokens are reserved in calling order, so waiting callers are served fairly
+func (b *TokenBucket) Wait(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	tokens := b.tokens
+	b.mu.Unlock()
+
+	if tokens < 0 {
+		time.Sleep(time.Duration(-tokens / b.rate * float64(time.Second)))
+	}
+}
+
+//LimitedReader reads from R no faster than every one of Buckets allows
+type LimitedReader struct {
+	R       io.Reader
+	Buckets []*TokenBucket
+}
+
+func (r *LimitedReader) Read(p []byte) (int, error) {
+	for _, b := range r.Buckets {
+		if b != nil && float64(len(p)) > b.burst {
+			p = p[:int(b.burst)]
+		}
+	}
+
+	n, err := r.R.Read(p)
+	for _, b := range r.Buckets {
+		b.Wait(n)
+	}
+	return n, err
+}
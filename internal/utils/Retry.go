@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+//RetryPolicy describes when and how long after failed HTTP requests are retried
+type RetryPolicy struct {
+	//MaxAttempts is number of attempts including the first one (1 disables retrying)
+	MaxAttempts int
+	//InitialBackoff is delay before first retry
+	InitialBackoff time.Duration
+	//MaxBackoff caps delay between attempts (Retry-After sent by server is not capped)
+	MaxBackoff time.Duration
+	//Multiplier multiplies delay after each attempt
+	Multiplier float64
+	//Jitter randomizes delay by ±Jitter fraction of it (0 ~ 1)
+	Jitter float64
+	//RetryableStatus is list of HTTP status codes to retry, connection errors are always retried
+	RetryableStatus []int
+	//OnRetry is called before waiting for next attempt
+	OnRetry func(RetryEvent)
+}
+
+//RetryEvent describes a failed attempt which is going to be retried
+type RetryEvent struct {
+	URL string
+	//Attempt is number of the failed attempt starting from 1
+	Attempt    int
+	Delay      time.Duration
+	StatusCode int
+	Err        error
+}
+
+//DefaultRetryPolicy is used if Downloader.Retry is nil
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:     4,
+	InitialBackoff:  500 * time.Millisecond,
+	MaxBackoff:      30 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+	RetryableStatus: []int{408, 429, 500, 502, 503, 504},
+}
+
+//Backoff returns delay before attempt + 1
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	mul := p.Multiplier
+	if mul < 1 {
+		mul = 1
+	}
+
+	delay := float64(p.InitialBackoff) * math.Pow(mul, float64(attempt-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(delay)
+}
+
+//shouldRetry returns delay before next attempt and whether err of attempt is retryable
+func (p *RetryPolicy) shouldRetry(attempt int, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	if errors.Is(err, e.ErrYtdl) {
+		// errors of ytdl itself are not transient
+		return 0, false
+	}
+
+	var he *httpError
+	if !errors.As(err, &he) {
+		// connection error
+		return p.Backoff(attempt), true
+	}
+	for _, code := range p.RetryableStatus {
+		if code == he.err.StatusCode {
+			if he.retryAfter > 0 {
+				return he.retryAfter, true
+			}
+			return p.Backoff(attempt), true
+		}
+	}
+	return 0, false
+}
+
+//httpError is *ytdlerrors.Error of non 2xx response with its Retry-After header
+type httpError struct {
+	err        *e.Error
+	retryAfter time.Duration
+}
+
+func (he *httpError) Error() string {
+	return he.err.Error()
+}
+
+func (he *httpError) Unwrap() error {
+	return he.err
+}
+
+func newHTTPError(res *http.Response) error {
+	return &httpError{
+		err:        &e.Error{Op: res.Request.Method, StatusCode: res.StatusCode, Err: e.ErrUnexpectedStatus},
+		retryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+	}
+}
+
+//parseRetryAfter parses Retry-After header given in seconds or HTTP date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(v); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
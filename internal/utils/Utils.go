@@ -2,67 +2,232 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"time"
 
-	e "github.com/sam1677/ytdl/internal/ytdlerrors"
+	"github.com/sam1677/ytdl/internal/logger"
+	e "github.com/sam1677/ytdl/ytdlerrors"
 )
 
+//Downloader downloads files and reports what it does to Logger
+type Downloader struct {
+	//Client sends requests (default: http.DefaultClient)
+	Client *http.Client
+	//Retry is retry policy of every request (default: DefaultRetryPolicy)
+	Retry *RetryPolicy
+	//Logger receives messages, nil means silent
+	Logger logger.Logger
+	//Bandwidth limits bytes per second of DownloadTo
+	Bandwidth []*TokenBucket
+	//Requests limits rate of Get requests
+	Requests *TokenBucket
+	//Prepare is called with every request before it's sent (e.g. to add headers)
+	Prepare func(req *http.Request)
+}
+
+func (d *Downloader) client() *http.Client {
+	if d.Client == nil {
+		return http.DefaultClient
+	}
+	return d.Client
+}
+
+func (d *Downloader) retryPolicy() *RetryPolicy {
+	if d.Retry == nil {
+		return DefaultRetryPolicy
+	}
+	return d.Retry
+}
+
+//Do sends request once and returns error for non 2xx response
+func (d *Downloader) Do(req *http.Request) (*http.Response, error) {
+	if d.Prepare != nil {
+		d.Prepare(req)
+	}
+
+	res, err := d.client().Do(req)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
+	if res.StatusCode/100 != 2 {
+		res.Body.Close()
+		return nil, e.DbgErr(newHTTPError(res))
+	}
+	return res, nil
+}
+
+//retry calls fn until it succeeds or RetryPolicy gives up
+//
+//attempts are counted again if fn made progress
+func (d *Downloader) retry(URL string, fn func() (progressed bool, err error)) error {
+	p := d.retryPolicy()
+	for attempt := 1; ; attempt++ {
+		progressed, err := fn()
+		if err == nil {
+			return nil
+		}
+		if progressed {
+			attempt = 1
+		}
+
+		delay, ok := p.shouldRetry(attempt, err)
+		if !ok {
+			return err
+		}
+
+		ev := RetryEvent{URL: URL, Attempt: attempt, Delay: delay, StatusCode: e.StatusCode(err), Err: err}
+		logger.Warn(d.Logger, "retrying request", "attempt", attempt, "delay", delay, "status", ev.StatusCode, "err", err)
+		if p.OnRetry != nil {
+			p.OnRetry(ev)
+		}
+		time.Sleep(delay)
+	}
+}
+
+//Get returns content of URL
+func (d *Downloader) Get(URL string) (data []byte, err error) {
+	logger.Debug(d.Logger, "fetching", "url", URL)
+	err = d.retry(URL, func() (bool, error) {
+		req, err := http.NewRequest(http.MethodGet, URL, nil)
+		if err != nil {
+			return false, e.DbgErr(err)
+		}
+
+		d.Requests.Wait(1)
+
+		res, err := d.Do(req)
+		if err != nil {
+			return false, err
+		}
+		defer res.Body.Close()
+
+		data, err = ioutil.ReadAll(res.Body)
+		return false, e.DbgErr(err)
+	})
+	return data, err
+}
+
 //DownloadFile Downloads file from given URL to path
 //
 //if onlyData is true
 //file won't saved and just return data of it
-func DownloadFile(URL string, path string, filename string, onlyData bool) (file *os.File, data []byte, err error) {
+func (d *Downloader) DownloadFile(URL string, path string, filename string, onlyData bool) (file *os.File, data []byte, err error) {
 	if !onlyData {
-		fmt.Println(filename, "Start downloading from :", URL)
-		defer fmt.Println(filename, "End downloading from :", URL)
+		logger.Info(d.Logger, "start downloading", "file", filename, "url", URL)
+		defer logger.Info(d.Logger, "end downloading", "file", filename)
 	}
 
-	res, err := http.Get(URL)
+	data, err = d.Get(URL)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	data, err = ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, nil, e.DbgErr(err)
-	}
-
-	defer res.Body.Close()
-
 	if onlyData {
 		return nil, data, nil
 	}
 
-	err = CreatePath(path)
+	file, err = d.CreateFile(path, filename)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	filename = MergePathAndFilename(path, filename)
-
-	file, err = os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0755)
+	_, err = file.Write(data)
 	if err != nil {
+		file.Close()
 		return nil, nil, err
 	}
 
-	_, err = file.Write(data)
+	return file, data, nil
+}
+
+//CreateFile creates path and empty file named filename in it
+func (d *Downloader) CreateFile(path, filename string) (*os.File, error) {
+	err := d.CreatePath(path)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	return os.OpenFile(MergePathAndFilename(path, filename), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0755)
+}
 
-	return file, data, nil
+//DownloadTo streams content of URL to w starting from offset byte
+//
+//Failed attempts are retried from where they stopped.
+//It returns number of bytes written to w even if error occurred,
+//so the download can be resumed by calling DownloadTo again with offset + n
+func (d *Downloader) DownloadTo(w io.Writer, URL string, offset int64) (n int64, err error) {
+	err = d.retry(URL, func() (bool, error) {
+		m, err := d.downloadTo(w, URL, offset+n, -1)
+		n += m
+		return m > 0, err
+	})
+	return n, err
+}
+
+//DownloadRange streams bytes from start to end (inclusive) of URL to w
+//
+//Failed attempts are retried from where they stopped
+func (d *Downloader) DownloadRange(w io.Writer, URL string, start, end int64) (n int64, err error) {
+	err = d.retry(URL, func() (bool, error) {
+		m, err := d.downloadTo(w, URL, start+n, end)
+		n += m
+		if err == nil && start+n <= end {
+			err = e.DbgErr(io.ErrUnexpectedEOF)
+		}
+		return m > 0, err
+	})
+	return n, err
+}
+
+//downloadTo streams bytes from offset to end (inclusive, -1 means the end of content) of URL to w
+func (d *Downloader) downloadTo(w io.Writer, URL string, offset, end int64) (n int64, err error) {
+	req, err := http.NewRequest(http.MethodGet, URL, nil)
+	if err != nil {
+		return 0, e.DbgErr(err)
+	}
+	if end >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, end))
+	} else if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		logger.Debug(d.Logger, "resuming download", "offset", offset)
+	}
+
+	res, err := d.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if req.Header.Get("Range") != "" && res.StatusCode != http.StatusPartialContent {
+		// server ignored Range header
+		_, err = io.CopyN(ioutil.Discard, res.Body, offset)
+		if err != nil {
+			return 0, e.DbgErr(err)
+		}
+	}
+
+	var body io.Reader = res.Body
+	if end >= 0 {
+		body = io.LimitReader(body, end-offset+1)
+	}
+	if len(d.Bandwidth) > 0 {
+		body = &LimitedReader{R: body, Buckets: d.Bandwidth}
+	}
+
+	n, err = io.Copy(w, body)
+	return n, e.DbgErr(err)
 }
 
 //CreatePath Check if folder exists and if does not it creates folder
-func CreatePath(path string) error {
+func (d *Downloader) CreatePath(path string) error {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		err = os.MkdirAll(path, 0755)
-		fmt.Println("Folder", path, "Created")
 		if err != nil {
 			return e.DbgErr(err)
 		}
+		logger.Debug(d.Logger, "folder created", "path", path)
 	}
 	return nil
 }
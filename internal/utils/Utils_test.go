@@ -1,9 +1,18 @@
 package utils_test
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sam1677/ytdl"
+	"github.com/sam1677/ytdl/internal/utils"
+	e "github.com/sam1677/ytdl/ytdlerrors"
 )
 
 func TestContentType(t *testing.T) {
@@ -12,3 +21,74 @@ func TestContentType(t *testing.T) {
 	t.Log(a & ytdl.Video)
 	t.Log(b & ytdl.Video)
 }
+
+func TestDownloadToResume(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "video.mp4", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	d := new(utils.Downloader)
+	buf := new(bytes.Buffer)
+	buf.WriteString(content[:400])
+
+	n, err := d.DownloadTo(buf, ts.URL, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 600 || buf.String() != content {
+		t.Errorf("resumed download is broken (n = %d)", n)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	failures := 2
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	events := []utils.RetryEvent{}
+	d := &utils.Downloader{Retry: &utils.RetryPolicy{
+		MaxAttempts:     3,
+		InitialBackoff:  time.Millisecond,
+		RetryableStatus: []int{http.StatusServiceUnavailable},
+		OnRetry:         func(ev utils.RetryEvent) { events = append(events, ev) },
+	}}
+
+	data, err := d.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "ok" || len(events) != 2 || events[1].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected result %q, events %+v", data, events)
+	}
+
+	failures = 3
+	_, err = d.Get(ts.URL)
+	if e.StatusCode(err) != http.StatusServiceUnavailable {
+		t.Errorf("got %v after attempts are exhausted", err)
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	b := utils.NewTokenBucket(10000, 1000)
+	r := &utils.LimitedReader{R: strings.NewReader(strings.Repeat("a", 3000)), Buckets: []*utils.TokenBucket{b}}
+
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != nil || n != 3000 {
+		t.Fatal(n, err)
+	}
+	// first 1000 bytes are burst, the rest takes 200ms at 10000 B/s
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("3000 bytes are read in %v", elapsed)
+	}
+}
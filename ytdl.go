@@ -1,97 +1,304 @@
 package ytdl
 
 import (
+	"errors"
+	"net/http"
 	"os"
+	"path/filepath"
+	"time"
 
-	"github.com/sam1677/ytdl/internal/ffmpeg"
+	"github.com/sam1677/ytdl/internal/logger"
 	u "github.com/sam1677/ytdl/internal/utils"
-	e "github.com/sam1677/ytdl/internal/ytdlerrors"
+	e "github.com/sam1677/ytdl/ytdlerrors"
 )
 
 const getVideoInfoURL = "https://www.youtube.com/get_video_info?video_id=%s"
 
+//Subdirectories of Client.CacheDir and temporary directory of each download
 const (
-	tmpJSONDir          = "./jsonCache"
-	tmpAudioDir         = "./audioCache"
-	tmpVideoDir         = "./videoCache"
-	tmpScriptDir        = "./scriptCache"
-	downloadDefaultPath = "./Downloads"
+	jsonCacheDir   = "jsonCache"
+	scriptCacheDir = "scriptCache"
+	tmpAudioDir    = "audio"
+	tmpVideoDir    = "video"
 )
 
 //DownloadOptions contains Download Path, Filename
 //and AudioFormat
 type DownloadOptions struct {
-	Path          string
-	Filename      string
+	Path     string
+	Filename string
+	//FilenameTemplate is used if Filename is empty (see FilenameData)
+	//
+	//Directories in expanded filename are created under Path
+	FilenameTemplate string
+	//Sanitize sanitizes filename expanded from FilenameTemplate (default: SanitizeOptions{})
+	//and Filename if it's not nil
+	Sanitize *SanitizeOptions
+	//OnCollision decides what to do if output file exists (default: Overwrite)
+	OnCollision CollisionPolicy
+	//WriteInfoJSON writes normalized metadata (InfoJSON) to <filename without ext>.info.json
+	//next to downloaded file
+	WriteInfoJSON bool
+	//AudioOverride is downloaded and merged with the video (see Merge)
 	AudioOverride *Format
+	//Container is container of output file: "mp4", "mkv", "webm" or "mov"
+	//
+	//Streams are copied if Container supports their codecs, others are transcoded.
+	//Empty Container keeps container of the video unless merged audio doesn't fit it,
+	//which is merged into mkv. Extension of Filename follows the container
+	Container string
+	//Subtitles are languages of caption tracks embedded as subtitle streams (see EmbedSubtitles)
+	Subtitles []string
+	//AutoSubtitles embeds auto generated caption tracks of Subtitles which don't have manual one
+	AutoSubtitles bool
+	//PostProcessors process downloaded media in order before it's moved to Path
+	//
+	//Merge is run first if AudioOverride is set and PostProcessors don't have it
+	PostProcessors []PostProcessor
+	//Start and End extract clip of the video (End 0: the end of video)
+	//
+	//Only segments covering the clip are downloaded, which needs InitRange and IndexRange of Format.
+	//Format without them is downloaded entirely.
+	//The clip is cut with ffmpeg copying streams, so it starts at keyframe before Start.
+	//Without ffmpeg, it's cut at segments containing Start and End (or not cut without index)
+	Start time.Duration
+	End   time.Duration
+	//ClipAccurate cuts clip at exact Start and End by reencoding with ffmpeg
+	ClipAccurate bool
+	//MaxBytesPerSecond caps download speed of this download (0: unlimited)
+	//
+	//Client.MaxBytesPerSecond is applied as well
+	MaxBytesPerSecond int64
+	//Archive is consulted before downloading and records successful downloads
+	//(default: Client.Archive)
+	//
+	//Download does nothing if the video is in Archive
+	Archive Archive
 }
 
 //Download Downloads format and overrides audio if AudioOverride is not nil
+//
+//Returned errors are *ytdlerrors.Error
 func (f *Format) Download(options *DownloadOptions) error {
-	if options == nil {
-		options = new(DownloadOptions)
+	err := f.download(options)
+	if errors.Is(err, errSkipped) {
+		return nil
 	}
+	return e.Wrap(err, "Download", f.videoID(), f.Itag)
+}
+
+//errSkipped is returned by download if video is in Archive or output file exists
+var errSkipped = e.New(e.ErrYtdl, "download is skipped")
+
+//client returns Client which got Format's VideoInfo
+func (f *Format) client() *Client {
+	if f.Parent == nil || f.Parent.client == nil {
+		return DefaultClient
+	}
+	return f.Parent.client
+}
+
+func (f *Format) videoID() string {
+	if f.Parent == nil {
+		return ""
+	}
+	return f.Parent.VideoDetails.VideoID
+}
+
+func (f *Format) download(opts *DownloadOptions) error {
+	// options are copied since expanded filename and path are written into them,
+	// so the caller can reuse opts for other videos
+	options := new(DownloadOptions)
+	if opts != nil {
+		*options = *opts
+	}
+	c := f.client()
+	if ok, err := c.archived(options, f.videoID()); ok || err != nil {
+		if ok {
+			logger.Info(c.Logger, "already downloaded, skipping", "videoID", f.videoID())
+			return errSkipped
+		}
+		return e.DbgErr(err)
+	}
+
 	if options.Path == "" {
-		options.Path = downloadDefaultPath
+		options.Path = c.downloadDir()
+	}
+	if options.Filename != "" && options.Sanitize != nil {
+		options.Filename = SanitizeFilename(options.Filename, *options.Sanitize)
+	}
+	if options.Filename == "" && options.FilenameTemplate != "" {
+		name, err := f.ExpandFilename(options.FilenameTemplate, options.AudioOverride)
+		if err != nil {
+			return e.DbgErr(err)
+		}
+
+		sanitize := SanitizeOptions{}
+		if options.Sanitize != nil {
+			sanitize = *options.Sanitize
+		}
+		dir, base := filepath.Split(SanitizePath(name, sanitize))
+		options.Path = filepath.Join(options.Path, dir)
+		options.Filename = base
 	}
 	if options.Filename == "" {
 		options.Filename = f.Filename
 	}
-	path := options.Path
-	if options.AudioOverride != nil {
-		path = tmpVideoDir
+
+	if options.AudioOverride != nil || options.Container != "" {
+		err := f.resolveContainer(options)
+		if err != nil {
+			return e.DbgErr(err)
+		}
 	}
 
-	file, err := f.downloadWithPath(path, options.Filename)
-	if err != nil {
-		return e.DbgErr(err)
+	filename, ok := resolveCollision(options.Path, options.Filename, options.OnCollision)
+	if !ok {
+		logger.Info(c.Logger, "file exists, skipping", "path", options.Path, "file", options.Filename)
+		return errSkipped
 	}
-	defer file.Close()
+	options.Filename = filename
 
-	if options.AudioOverride != nil {
-		err = f.audioOverride(options.AudioOverride, file, options.Path)
+	var limit *u.TokenBucket
+	if options.MaxBytesPerSecond > 0 {
+		limit = newBandwidthLimiter(options.MaxBytesPerSecond)
+	}
+
+	if options.AudioOverride == nil && options.Container == "" && len(options.Subtitles) == 0 && !options.isClip() && len(options.PostProcessors) == 0 {
+		file, err := f.downloadWithPath(options.Path, options.Filename, limit)
+		if err != nil {
+			return e.DbgErr(err)
+		}
+		err = file.Close()
 		if err != nil {
 			return e.DbgErr(err)
 		}
+		return f.finish(options)
 	}
-	return nil
+	return f.downloadAndProcess(options, limit)
 }
 
-func (f *Format) downloadWithPath(path string, filename string) (*os.File, error) {
-	if f.URL == "" {
-		return nil, e.DbgErr(e.ErrURLIsEmpty)
+//finish writes info.json and records download in Archive after successful download
+func (f *Format) finish(options *DownloadOptions) error {
+	if options.WriteInfoJSON && f.Parent != nil {
+		err := f.Parent.writeInfoJSON(options.Path, options.Filename, f, options.AudioOverride)
+		if err != nil {
+			return e.DbgErr(err)
+		}
 	}
+	return f.addToArchive(options)
+}
 
-	file, _, err := u.DownloadFile(f.URL, path, filename, false)
-	if err != nil {
-		return nil, e.DbgErr(err)
+//addToArchive records successful download in Archive of options or Client
+func (f *Format) addToArchive(options *DownloadOptions) error {
+	a := f.client().archive(options)
+	if a == nil {
+		return nil
+	}
+
+	entry := ArchiveEntry{VideoID: f.videoID(), Itags: []int{f.Itag}}
+	if options.AudioOverride != nil {
+		entry.Itags = append(entry.Itags, options.AudioOverride.Itag)
 	}
-	return file, nil
+	return e.DbgErr(a.Add(entry))
 }
 
-func (f *Format) audioOverride(audio *Format, videoFile *os.File, finalDir string) error {
-	audioFile, err := audio.downloadWithPath(tmpAudioDir, audio.Filename)
-	if err != nil {
-		return e.DbgErr(err)
+//maxURLRefresh is how many times stream URL is refreshed during one download
+const maxURLRefresh = 2
+
+//partSuffix is appended to filename until download succeeds
+const partSuffix = ".part"
+
+//downloadWithPath downloads Format into path/filename
+//
+//If stream URL is expired or rejected with 403 Forbidden,
+//VideoInfo is refreshed and download resumes from where it stopped.
+//Format is downloaded into filename.part which is renamed to filename on success
+//and removed on failure, so failed download doesn't leave truncated file
+//
+//limit is per download bandwidth limiter and may be nil
+func (f *Format) downloadWithPath(path string, filename string, limit *u.TokenBucket) (*os.File, error) {
+	c := f.client()
+	d := c.downloader(f.videoID())
+	if limit != nil {
+		d.Bandwidth = append(d.Bandwidth, limit)
 	}
 
-	ff := new(ffmpeg.FFMpeg)
+	part, err := d.CreateFile(path, filename+partSuffix)
+	if err != nil {
+		return nil, e.DbgErr(err)
+	}
 
-	err = ff.Init()
+	logger.Info(c.Logger, "start downloading", "file", filename, "itag", f.Itag)
+	var written int64
+	err = f.withFreshURL(func() error {
+		n, err := d.DownloadTo(part, f.URL, written)
+		written += n
+		return err
+	})
+	if cerr := part.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
-		return e.DbgErr(err)
+		os.Remove(part.Name())
+		return nil, e.DbgErr(err)
 	}
 
-	vinfo, err := videoFile.Stat()
+	name := u.MergePathAndFilename(path, filename)
+	err = os.Rename(part.Name(), name)
 	if err != nil {
-		return err
+		os.Remove(part.Name())
+		return nil, e.DbgErr(err)
 	}
 
-	err = ff.MergeVideoNAudio(videoFile, audioFile, finalDir, vinfo.Name())
+	logger.Info(c.Logger, "end downloading", "file", filename, "size", written)
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	return file, e.DbgErr(err)
+}
+
+//withFreshURL calls fn which requests stream URL of Format
+//
+//VideoInfo is refreshed before calling fn if stream URL is expired,
+//and fn is called again after refreshing if it fails with 403 Forbidden (maxURLRefresh times in total)
+func (f *Format) withFreshURL(fn func() error) error {
+	c := f.client()
+	for refreshed := 0; ; {
+		if f.Parent != nil && f.Parent.Expired() && refreshed < maxURLRefresh {
+			logger.Info(c.Logger, "stream URL is expired, refreshing", "itag", f.Itag)
+			refreshed++
+			if err := f.refresh(); err != nil {
+				return e.DbgErr(err)
+			}
+		}
+		if f.URL == "" {
+			return e.DbgErr(e.ErrURLIsEmpty)
+		}
+
+		err := fn()
+		if err == nil || e.StatusCode(err) != http.StatusForbidden || refreshed >= maxURLRefresh {
+			return e.DbgErr(err)
+		}
+
+		logger.Warn(c.Logger, "stream URL is rejected, refreshing", "itag", f.Itag)
+		refreshed++
+		if err = f.refresh(); err != nil {
+			return e.DbgErr(err)
+		}
+	}
+}
+
+//refresh refreshes VideoInfo which Format belongs to and checks if Format is still available
+func (f *Format) refresh() error {
+	if f.Parent == nil {
+		return e.DbgErr(e.ErrURLIsEmpty)
+	}
+
+	err := f.Parent.Refresh()
 	if err != nil {
 		return e.DbgErr(err)
 	}
-
-	audioFile.Close()
+	if f.URL == "" {
+		return e.DbgErr(e.ErrFormatNotFound)
+	}
 	return nil
 }
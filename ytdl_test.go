@@ -2,6 +2,11 @@ package ytdl_test
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/sam1677/ytdl"
@@ -37,3 +42,27 @@ func TestDownload(t *testing.T) {
 		return
 	}
 }
+
+func TestDownloadFailureRemovesPart(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("truncated"))
+	}))
+	defer ts.Close()
+
+	c, dir := newCachedClient(t, "9bZkp7q19f0", ts.URL)
+	defer os.RemoveAll(dir)
+	c.Retry = &ytdl.RetryPolicy{MaxAttempts: 1}
+
+	vi, err := c.GetVideoInfo("9bZkp7q19f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vi.StreamingData.AdaptiveFormats.Videos().Best().Download(&ytdl.DownloadOptions{Filename: "video.mp4"})
+	if err == nil {
+		t.Fatal("truncated download succeeded")
+	}
+	if files, _ := ioutil.ReadDir(filepath.Join(dir, "Downloads")); len(files) != 0 {
+		t.Errorf("files are left after failed download: %v", files)
+	}
+}
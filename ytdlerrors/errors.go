@@ -0,0 +1,207 @@
+//Package ytdlerrors contains errors returned by ytdl
+//
+//Every error returned by ytdl can be inspected with errors.Is and errors.As.
+//Sentinel errors belong to a category (ErrYtdl, ErrCipher, ErrRegexp, ErrHTTP,
+//ErrUnplayable, ErrFFMpeg) so callers can branch on a whole group of failures:
+//
+//	if errors.Is(err, ytdlerrors.ErrUnplayable) { ... }
+//
+//Failures of an operation are wrapped in *Error which carries the operation,
+//video ID, itag and HTTP status code of the failed request.
+package ytdlerrors
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+//Categories of errors
+var (
+	//ErrYtdl Describes YoutubeDownloader Error
+	ErrYtdl = errors.New("ytdl error")
+	//ErrCipher Describes Cipher Error
+	ErrCipher = errors.New("cipher error")
+	//ErrRegexp Describes Regexp Error
+	ErrRegexp = errors.New("regexp error")
+	//ErrHTTP Describes HTTP Error
+	ErrHTTP = errors.New("http error")
+	//ErrUnplayable Describes Youtube refusing to play a video
+	ErrUnplayable = errors.New("video is not playable")
+	//ErrFFMpeg Describes FFMpeg Error
+	ErrFFMpeg = errors.New("ffmpeg error")
+)
+
+var (
+	//ErrURLIsEmpty URL is Empty
+	ErrURLIsEmpty = New(ErrYtdl, "URL is empty. (Check if Format's URL is Ciphered)")
+	//ErrFormatNotFound Format with the itag is not in refreshed VideoInfo
+	ErrFormatNotFound = New(ErrYtdl, "format is not available anymore")
+	//ErrJSstrIsEmpty JSstr is empty
+	ErrJSstrIsEmpty = New(ErrCipher, "Js string is empty")
+	//ErrFunctionNotFound transform function is not found in TransformMap
+	ErrFunctionNotFound = New(ErrCipher, "transform function is not found")
+	//ErrRegexpNotMatched RegexpNotMatched
+	ErrRegexpNotMatched = New(ErrRegexp, "Regexp Search Error: Not Matched")
+	//ErrFuncListIsTooShort len(functionList) < 3
+	ErrFuncListIsTooShort = New(ErrRegexp, "Regexp Match Error: function list length is less than 3")
+	//ErrNoMatchOnFunction function regexp not matched
+	ErrNoMatchOnFunction = New(ErrRegexp, "Regexp Match Error: No Match on function")
+	//ErrCacheMiss there's nothing cached
+	ErrCacheMiss = New(ErrYtdl, "not found in cache")
+	//ErrCacheExpired cached stream URLs are expired
+	ErrCacheExpired = New(ErrYtdl, "cache is expired")
+	//ErrUnexpectedStatus server responded with non 2xx status code
+	ErrUnexpectedStatus = New(ErrHTTP, "unexpected HTTP status")
+	//ErrFFMpegFailed ffmpeg exited with non zero code
+	ErrFFMpegFailed = New(ErrFFMpeg, "ffmpeg exited with error")
+	//ErrFFMpegNotFound ffmpeg executable is not found
+	ErrFFMpegNotFound = New(ErrFFMpeg, "ffmpeg is not found (set FFMPEG or install ffmpeg into PATH)")
+	//ErrEncoderNotAvailable ffmpeg is built without required encoder
+	ErrEncoderNotAvailable = New(ErrFFMpeg, "encoder is not available")
+	//ErrMuxerNotAvailable ffmpeg is built without required muxer
+	ErrMuxerNotAvailable = New(ErrFFMpeg, "muxer is not available")
+	//ErrFFProbeNotFound ffprobe executable is not found
+	ErrFFProbeNotFound = New(ErrFFMpeg, "ffprobe is not found (set FFPROBE or install ffprobe into PATH)")
+	//ErrInvalidOutput merged file lacks streams or has wrong duration
+	ErrInvalidOutput = New(ErrFFMpeg, "output file is invalid")
+)
+
+//Reasons of ErrUnplayable
+var (
+	//ErrVideoPrivate video is private
+	ErrVideoPrivate = New(ErrUnplayable, "video is private")
+	//ErrAgeRestricted video requires age verification
+	ErrAgeRestricted = New(ErrUnplayable, "video is age restricted")
+	//ErrLoginRequired video requires signing in
+	ErrLoginRequired = New(ErrUnplayable, "login required")
+	//ErrGeoBlocked video is not available in this country
+	ErrGeoBlocked = New(ErrUnplayable, "video is not available in this country")
+	//ErrVideoUnavailable video is removed, does not exist or is unplayable
+	ErrVideoUnavailable = New(ErrUnplayable, "video is unavailable")
+	//ErrLiveNotStarted live stream or premiere has not started yet
+	ErrLiveNotStarted = New(ErrUnplayable, "live stream has not started")
+)
+
+type categorized struct {
+	msg      string
+	category error
+}
+
+func (c *categorized) Error() string {
+	return c.msg
+}
+
+func (c *categorized) Unwrap() error {
+	return c.category
+}
+
+//New creates sentinel error which errors.Is reports as category
+func New(category error, msg string) error {
+	return &categorized{msg: msg, category: category}
+}
+
+//Error describes failed operation
+type Error struct {
+	//Op is failed operation (e.g. "GetVideoInfo", "Download")
+	Op string
+	//VideoID is Youtube video ID if known
+	VideoID string
+	//Itag is Format's itag if known
+	Itag int
+	//StatusCode is HTTP status code of failed request if known
+	StatusCode int
+	//Err is the cause
+	Err error
+}
+
+func (err *Error) Error() string {
+	var sb strings.Builder
+	sb.WriteString("ytdl: ")
+	sb.WriteString(err.Op)
+	if err.VideoID != "" {
+		sb.WriteString(" " + err.VideoID)
+	}
+	if err.Itag != 0 {
+		fmt.Fprintf(&sb, " (itag %d)", err.Itag)
+	}
+	if err.StatusCode != 0 {
+		fmt.Fprintf(&sb, " [HTTP %d]", err.StatusCode)
+	}
+	if err.Err != nil {
+		sb.WriteString(": " + err.Err.Error())
+	}
+	return sb.String()
+}
+
+//Unwrap returns the cause
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+//Wrap wraps err into *Error
+//
+//Fields which are not given are inherited from *Error already in err's chain,
+//so the outermost *Error always carries everything known about the failure.
+//Wrap returns nil if err is nil
+func Wrap(err error, op, videoID string, itag int) error {
+	if err == nil {
+		return nil
+	}
+	ret := &Error{Op: op, VideoID: videoID, Itag: itag, Err: err}
+
+	var inner *Error
+	if errors.As(err, &inner) {
+		if ret.VideoID == "" {
+			ret.VideoID = inner.VideoID
+		}
+		if ret.Itag == 0 {
+			ret.Itag = inner.Itag
+		}
+		ret.StatusCode = inner.StatusCode
+	}
+	return ret
+}
+
+//StatusCode returns HTTP status code carried by err and 0 if there's none
+func StatusCode(err error) int {
+	var ye *Error
+	if errors.As(err, &ye) {
+		return ye.StatusCode
+	}
+	return 0
+}
+
+//DbgMode activates debug mode
+var DbgMode = false
+
+//DbgErr adds caller string to error
+//
+//The returned error wraps err so errors.Is and errors.As keep working
+func DbgErr(err error) error {
+	if err == nil || !DbgMode {
+		return err
+	}
+
+	_, file, line, ok := runtime.Caller(1)
+	if !ok {
+		return err
+	}
+	return fmt.Errorf("%w\n\t(From %s:%d)", err, file, line)
+}
+
+//IsYtdlErr Checks if err is YtdlErr
+func IsYtdlErr(err error) bool {
+	return errors.Is(err, ErrYtdl)
+}
+
+//IsCipherErr Checks if err is CipherErr
+func IsCipherErr(err error) bool {
+	return errors.Is(err, ErrCipher)
+}
+
+//IsRegexpErr Checks if err is RegexpErr
+func IsRegexpErr(err error) bool {
+	return errors.Is(err, ErrRegexp)
+}
@@ -0,0 +1,46 @@
+package ytdlerrors_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	e "github.com/sam1677/ytdl/ytdlerrors"
+)
+
+func TestCategories(t *testing.T) {
+	if !e.IsRegexpErr(e.ErrRegexpNotMatched) {
+		t.Error("ErrRegexpNotMatched is not RegexpErr")
+	}
+	if e.IsRegexpErr(e.ErrURLIsEmpty) || e.IsCipherErr(e.ErrURLIsEmpty) {
+		t.Error("ErrURLIsEmpty is reported as every category")
+	}
+	if !errors.Is(e.ErrVideoPrivate, e.ErrUnplayable) {
+		t.Error("ErrVideoPrivate is not ErrUnplayable")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	e.DbgMode = true
+	defer func() { e.DbgMode = false }()
+
+	inner := e.DbgErr(&e.Error{Op: "GET", StatusCode: 403, Err: e.ErrUnexpectedStatus})
+	err := e.Wrap(fmt.Errorf("fetching: %w", inner), "Download", "9bZkp7q19f0", 137)
+
+	var ye *e.Error
+	if !errors.As(err, &ye) {
+		t.Fatal("err is not *Error")
+	}
+	if ye.Op != "Download" || ye.VideoID != "9bZkp7q19f0" || ye.Itag != 137 || ye.StatusCode != 403 {
+		t.Errorf("unexpected fields %+v", ye)
+	}
+	if !errors.Is(err, e.ErrUnexpectedStatus) || !errors.Is(err, e.ErrHTTP) {
+		t.Error("cause is lost by wrapping")
+	}
+	if e.StatusCode(err) != 403 {
+		t.Errorf("StatusCode = %d", e.StatusCode(err))
+	}
+	if e.Wrap(nil, "Download", "", 0) != nil {
+		t.Error("Wrap(nil) is not nil")
+	}
+}